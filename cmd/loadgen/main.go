@@ -0,0 +1,105 @@
+// Command loadgen replays a payment arrival curve against the server, checks
+// its payments summary against the payments sent and prints a score, so
+// performance changes can be compared between runs.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/loadgen"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:9999", "server or load balancer under test")
+	stages := flag.String("stages", "10s:100,30s:500,10s:0", "arrival curve as duration:rate stages, ramping linearly to each rate")
+	startRate := flag.Float64("start-rate", 0, "arrival rate at the start of the first stage, in payments per second")
+	amount := flag.String("amount", "19.90", "amount of every payment")
+	maxInFlight := flag.Int("max-in-flight", 500, "maximum concurrent requests")
+	timeout := flag.Duration("timeout", 5*time.Second, "request timeout")
+	checkInterval := flag.Duration("check-interval", 2*time.Second, "interval between payments summary checks")
+	drain := flag.Duration("drain", 10*time.Second, "how long to wait for accepted payments to be processed")
+	defaultProcessor := flag.String("default-processor", "default", "processor whose payments are not fallbacks")
+	fees := flag.String("fees", "default=0.05,fallback=0.15", "fee of each processor, as name=fraction pairs")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	curveStages, err := loadgen.ParseStages(*stages)
+	if err != nil {
+		log.Fatalf("Invalid -stages: %v", err)
+	}
+
+	paymentAmount, err := models.ParseMoney(*amount)
+	if err != nil || paymentAmount <= 0 {
+		log.Fatalf("Invalid -amount: %s", *amount)
+	}
+
+	processorFees, err := parseFees(*fees)
+	if err != nil {
+		log.Fatalf("Invalid -fees: %v", err)
+	}
+
+	runner := loadgen.NewRunner(loadgen.Config{
+		BaseURL:          strings.TrimRight(*baseURL, "/"),
+		Curve:            loadgen.Curve{Start: *startRate, Stages: curveStages},
+		Amount:           paymentAmount,
+		MaxInFlight:      *maxInFlight,
+		Timeout:          *timeout,
+		CheckInterval:    *checkInterval,
+		Drain:            *drain,
+		DefaultProcessor: *defaultProcessor,
+		Fees:             processorFees,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := runner.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	report.Print(os.Stdout)
+}
+
+func parseFees(value string) (map[string]float64, error) {
+	fees := make(map[string]float64)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, feeValue, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fee %q, expected name=fraction", entry)
+		}
+
+		fee, err := strconv.ParseFloat(feeValue, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fee %q: %w", entry, err)
+		}
+		fees[name] = fee
+	}
+
+	return fees, nil
+}
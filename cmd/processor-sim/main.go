@@ -0,0 +1,57 @@
+// Command processor-sim runs a simulated payment processor implementing the
+// Rinha payment processor HTTP API, with configurable failures, so the server
+// can be developed and load tested without the official processors.
+package main
+
+import (
+	"flag"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/simulator"
+	"log"
+	"net/http"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	name := flag.String("name", "default", "processor name, only used in logs")
+	fee := flag.Float64("fee", 0.05, "fee charged per payment, as a fraction of its amount")
+	token := flag.String("token", "123", "token required on the admin routes (X-Rinha-Token)")
+	errorRate := flag.Float64("error-rate", 0, "fraction of payments failing with a 500")
+	ambiguousRate := flag.Float64("ambiguous-rate", 0, "fraction of payments recorded but answered only after -ambiguous-delay")
+	ambiguousDelay := flag.Duration("ambiguous-delay", 2*time.Second, "delay of ambiguous payments")
+	distribution := flag.String("latency", "uniform", "latency distribution: fixed, uniform or exponential")
+	latencyMin := flag.Duration("latency-min", 1*time.Millisecond, "minimum payment latency")
+	latencyMax := flag.Duration("latency-max", 10*time.Millisecond, "maximum payment latency")
+	outages := flag.String("outages", "", "outage windows since start, e.g. 10s-20s,1m-1m30s")
+	healthRateLimit := flag.Duration("health-rate-limit", 5*time.Second, "minimum interval between health checks, 0 disables it")
+	flag.Parse()
+
+	outageWindows, err := simulator.ParseOutages(*outages)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := simulator.Config{
+		Name:           *name,
+		Fee:            *fee,
+		Token:          *token,
+		ErrorRate:      *errorRate,
+		AmbiguousRate:  *ambiguousRate,
+		AmbiguousDelay: *ambiguousDelay,
+		Latency: simulator.Latency{
+			Distribution: *distribution,
+			Min:          *latencyMin,
+			Max:          *latencyMax,
+		},
+		Outages:         outageWindows,
+		HealthRateLimit: *healthRateLimit,
+	}
+	if err := cfg.Latency.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Processor simulator %s listening on %s\n", *name, *addr)
+	if err := http.ListenAndServe(*addr, simulator.NewSimulator(cfg)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+// Command reconcile compares the stored payments summary with the summaries
+// recorded by the processors and prints the report. It exits with status 1
+// when discrepancies are found.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/reconciliation"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	fromFlag := flag.String("from", "", "start of the window, RFC 3339 (default: RECONCILE_WINDOW before -to)")
+	toFlag := flag.String("to", "", "end of the window, RFC 3339 (default: RECONCILE_LAG ago)")
+	all := flag.Bool("all", false, "reconcile every payment, ignoring -from and -to")
+	save := flag.Bool("save", false, "save the report as the latest one served on /admin/reconciliation")
+	flag.Parse()
+
+	cfg := config.NewConfig()
+	ctx := context.Background()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: fmt.Sprintf("%s:%s", cfg.Host, cfg.Cache.Port),
+	})
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Error connecting to Redis: %v", err)
+	}
+
+	processors := processor.NewProcessors(cfg.Processors)
+	storageService := storage.NewStorageService(rdb, processor.Names(processors))
+	reconciliationService := reconciliation.NewReconciliationService(processors, storageService, rdb, cfg.Reconciliation)
+
+	var from, to *time.Time
+	if !*all {
+		windowFrom, windowTo := reconciliationService.Window(time.Now())
+		from, to = &windowFrom, &windowTo
+
+		if *toFlag != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, *toFlag)
+			if err != nil {
+				log.Fatalf("Invalid -to: %v", err)
+			}
+			windowFrom = parsed.Add(-cfg.ReconcileWindow)
+			windowTo = parsed
+		}
+
+		if *fromFlag != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, *fromFlag)
+			if err != nil {
+				log.Fatalf("Invalid -from: %v", err)
+			}
+			windowFrom = parsed
+		}
+	}
+
+	report, err := reconciliationService.Reconcile(ctx, from, to)
+	if err != nil {
+		log.Fatalf("Error reconciling payments: %v", err)
+	}
+
+	if *save {
+		if err := reconciliationService.Save(ctx, report); err != nil {
+			log.Fatalf("Error saving report: %v", err)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Error encoding report: %v", err)
+	}
+
+	if report.HasDiscrepancies {
+		os.Exit(1)
+	}
+}
@@ -3,13 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"francoggm/rinhabackend-2025-go-redis/internal/app/healthcheck"
-	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
-	"francoggm/rinhabackend-2025-go-redis/internal/app/server"
-	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
-	"francoggm/rinhabackend-2025-go-redis/internal/app/worker"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/bootstrap"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
 	"francoggm/rinhabackend-2025-go-redis/internal/config"
-	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"os/signal"
+	"syscall"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -33,22 +32,52 @@ func main() {
 		panic(err)
 	}
 
-	// Worker queues
-	events := make(chan *models.Payment, cfg.PaymentBufferSize)
-
-	// Services
-	healthCheckService := healthcheck.NewHealthCheckService(cfg.DefaultURL, cfg.FallbackURL, rdb)
-	paymentService := payment.NewPaymentService(cfg.DefaultURL, cfg.FallbackURL, healthCheckService)
-	storageService := storage.NewStorageService(rdb)
+	app, err := bootstrap.NewApp(ctx, cfg, rdb)
+	if err != nil {
+		panic(err)
+	}
 
-	// Start workers in order of processing
-	pool := worker.NewWorkerPool(cfg.PaymentCount, events, paymentService, storageService)
-	pool.StartWorkers(ctx)
+	metrics.QueueCapacity.Set(float64(cfg.PaymentBufferSize))
+	metrics.RegisterQueueDepth(func() float64 {
+		depth, err := app.Queue.Len(ctx)
+		if err != nil {
+			return 0
+		}
+		return float64(depth)
+	})
+	metrics.RegisterRetryDepth(func() float64 {
+		depth, err := app.RetryScheduler.Len(ctx)
+		if err != nil {
+			return 0
+		}
+		return float64(depth)
+	})
 
-	server := server.NewServer(cfg, events, storageService)
-	if err := server.Run(); err != nil {
+	if err := app.Start(ctx); err != nil {
 		panic(err)
 	}
 
-	close(events)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- app.Server.Run()
+	}()
+
+	signalCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			panic(err)
+		}
+	case <-signalCtx.Done():
+		log.Println("Shutting down, draining payments")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down: %v\n", err)
+	}
 }
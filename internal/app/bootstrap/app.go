@@ -0,0 +1,146 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/healthcheck"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/idempotency"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/reconciliation"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/retry"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/routing"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/server"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/worker"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// App is one server instance: its payment queue, services, workers and HTTP
+// server, wired the same way by cmd/server and the integration tests.
+type App struct {
+	Queue                 queue.Queue
+	Server                *server.Server
+	Pool                  *worker.WorkerPool
+	HealthCheckService    *healthcheck.HealthCheckService
+	StorageService        *storage.StorageService
+	RetryScheduler        *retry.RetryScheduler
+	ReconciliationService *reconciliation.ReconciliationService
+}
+
+func NewApp(ctx context.Context, cfg *config.Config, cache *redis.Client) (*App, error) {
+	paymentQueue, err := newPaymentQueue(ctx, cfg, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	routingPolicy, err := routing.NewPolicy(cfg.Routing)
+	if err != nil {
+		return nil, err
+	}
+
+	retryPolicy, err := payment.NewRetryPolicy(cfg.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	retryBackoff, err := retry.NewBackoff(cfg.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	processors := processor.NewProcessors(cfg.Processors)
+	healthCheckService := healthcheck.NewHealthCheckService(processors, cache, routingPolicy, cfg.HealthCheck)
+	circuitBreaker := payment.NewCircuitBreaker(cache, cfg.Breaker)
+
+	paymentService := payment.NewPaymentService(processors, circuitBreaker, retryPolicy, healthCheckService)
+	storageService := storage.NewStorageService(cache, processor.Names(processors))
+	statusService := status.NewStatusService(cache, cfg.StatusTTL)
+	idempotencyService := idempotency.NewIdempotencyService(cache, storageService, statusService, cfg.IdempotencyTTL)
+	deadLetterService := deadletter.NewDeadLetterService(cache, paymentQueue, paymentService, storageService, statusService)
+	retryScheduler := retry.NewRetryScheduler(cache, retryBackoff, cfg.Retry)
+	reconciliationService := reconciliation.NewReconciliationService(processors, storageService, cache, cfg.Reconciliation)
+
+	pool := worker.NewWorkerPool(cfg.PaymentCount, paymentQueue, retryScheduler, paymentService, storageService, statusService, deadLetterService)
+	srv := server.NewServer(cfg, paymentQueue, storageService, idempotencyService, statusService, deadLetterService, retryScheduler, processors, reconciliationService)
+
+	return &App{
+		Queue:                 paymentQueue,
+		Server:                srv,
+		Pool:                  pool,
+		HealthCheckService:    healthCheckService,
+		StorageService:        storageService,
+		RetryScheduler:        retryScheduler,
+		ReconciliationService: reconciliationService,
+	}, nil
+}
+
+// Start starts the health checks and migrates the stored payments, then
+// starts the workers, queues again the payments left unprocessed by the last
+// shutdown and starts the reconciliation job. The HTTP server is left to the
+// caller.
+func (a *App) Start(ctx context.Context) error {
+	a.HealthCheckService.Start(ctx)
+
+	// Index payments stored by older versions before serving summaries
+	if err := a.StorageService.MigrateLegacyPayments(ctx); err != nil {
+		return err
+	}
+
+	// Start workers in order of processing
+	a.Pool.StartWorkers(ctx)
+
+	if err := a.Pool.RestoreUnprocessed(ctx); err != nil {
+		log.Printf("Error restoring unprocessed payments: %v\n", err)
+	}
+
+	a.ReconciliationService.Start(ctx)
+
+	return nil
+}
+
+// Shutdown stops accepting payments, then gives the workers what is left of
+// ctx to drain the queue and retries, persisting anything still unprocessed.
+// Health check leadership is released even when draining used up ctx.
+func (a *App) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	if err := a.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("shutting down server: %w", err))
+	}
+
+	a.Queue.Close()
+	a.ReconciliationService.Shutdown()
+
+	if err := a.Pool.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("persisting unprocessed payments: %w", err))
+	}
+
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+
+	if err := a.HealthCheckService.Shutdown(releaseCtx); err != nil {
+		errs = append(errs, fmt.Errorf("releasing health check leadership: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func newPaymentQueue(ctx context.Context, cfg *config.Config, cache *redis.Client) (queue.Queue, error) {
+	switch cfg.QueueDriver {
+	case "memory":
+		return queue.NewMemoryQueue(cfg.PaymentBufferSize, cfg.AdmissionWait), nil
+	case "redis":
+		return queue.NewRedisQueue(ctx, cache, cfg.QueueConsumer, cfg.PaymentBufferSize, cfg.AdmissionWait, cfg.QueueClaimMinIdle)
+	}
+
+	return nil, fmt.Errorf("unknown queue driver: %s", cfg.QueueDriver)
+}
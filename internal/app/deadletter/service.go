@@ -0,0 +1,225 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	deadLettersKey      = "dead_letters"
+	deadLettersIndexKey = "dead_letters_index"
+)
+
+var ErrDeadLetterNotFound = fmt.Errorf("dead letter not found")
+
+type DeadLetterService struct {
+	cache          *redis.Client
+	paymentQueue   queue.Queue
+	paymentService *payment.PaymentService
+	storageService *storage.StorageService
+	statusService  *status.StatusService
+}
+
+func NewDeadLetterService(cache *redis.Client, paymentQueue queue.Queue, paymentService *payment.PaymentService, storageService *storage.StorageService, statusService *status.StatusService) *DeadLetterService {
+	return &DeadLetterService{
+		cache:          cache,
+		paymentQueue:   paymentQueue,
+		paymentService: paymentService,
+		storageService: storageService,
+		statusService:  statusService,
+	}
+}
+
+func (s *DeadLetterService) Add(ctx context.Context, deadLetter *models.DeadLetter) error {
+	payload, err := sonic.ConfigFastest.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	id := deadLetter.Payment.CorrelationID
+	_, err = s.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, deadLettersKey, id, payload)
+		pipe.ZAdd(ctx, deadLettersIndexKey, redis.Z{
+			Score:  float64(deadLetter.FailedAt.UnixMilli()),
+			Member: id,
+		})
+		return nil
+	})
+
+	return err
+}
+
+// List returns dead letters ordered from the most recent failure.
+func (s *DeadLetterService) List(ctx context.Context, offset, limit int64) (*models.DeadLetterList, error) {
+	total, err := s.cache.ZCard(ctx, deadLettersIndexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &models.DeadLetterList{
+		Total: total,
+		Items: []models.DeadLetter{},
+	}
+
+	ids, err := s.cache.ZRevRange(ctx, deadLettersIndexKey, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return list, nil
+	}
+
+	values, err := s.cache.HMGet(ctx, deadLettersKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var deadLetter models.DeadLetter
+		if err := sonic.ConfigFastest.UnmarshalFromString(data, &deadLetter); err != nil {
+			return nil, err
+		}
+
+		list.Items = append(list.Items, deadLetter)
+	}
+
+	return list, nil
+}
+
+func (s *DeadLetterService) Get(ctx context.Context, correlationID string) (*models.DeadLetter, error) {
+	data, err := s.cache.HGet(ctx, deadLettersKey, correlationID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var deadLetter models.DeadLetter
+	if err := sonic.ConfigFastest.Unmarshal(data, &deadLetter); err != nil {
+		return nil, err
+	}
+
+	return &deadLetter, nil
+}
+
+// Requeue removes the dead letter and sends its payment back to the payment
+// queue. The processors that may have charged the payment are asked for it
+// first: a payment one of them has is stored as succeeded on it and returned
+// instead of being queued. The dead letter is kept if the processors cannot
+// tell and restored if the payment cannot be queued.
+func (s *DeadLetterService) Requeue(ctx context.Context, correlationID string) (*models.Payment, error) {
+	deadLetter, err := s.Get(ctx, correlationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *models.Payment
+	if len(deadLetter.AmbiguousProcessors) > 0 {
+		found, err = s.paymentService.LookupPayment(ctx, correlationID, deadLetter.AmbiguousProcessors)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.Discard(ctx, correlationID); err != nil {
+		return nil, err
+	}
+
+	if found != nil {
+		return s.recordCharged(ctx, deadLetter, found)
+	}
+
+	payment := deadLetter.Payment
+	payment.ProcessingType = ""
+	payment.RequestedAt = time.Time{}
+
+	if err := s.paymentQueue.Enqueue(ctx, payment); err != nil {
+		return nil, s.restore(ctx, deadLetter, err)
+	}
+
+	err = s.statusService.Transition(ctx, payment, models.PaymentQueued, nil)
+	if err != nil && !errors.Is(err, status.ErrInvalidTransition) {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// recordCharged stores the payment of a dead letter as succeeded on the
+// processor found to have charged it.
+func (s *DeadLetterService) recordCharged(ctx context.Context, deadLetter *models.DeadLetter, found *models.Payment) (*models.Payment, error) {
+	payment := deadLetter.Payment
+	payment.ProcessingType = found.ProcessingType
+	if !found.RequestedAt.IsZero() {
+		payment.RequestedAt = found.RequestedAt
+	}
+
+	if err := s.storageService.SavePayment(ctx, payment); err != nil {
+		return nil, s.restore(ctx, deadLetter, err)
+	}
+
+	err := s.statusService.Transition(ctx, payment, models.PaymentSucceeded, &models.PaymentAttempt{
+		Processor:   found.ProcessingType,
+		StatusCode:  http.StatusOK,
+		AttemptedAt: time.Now().UTC(),
+	})
+	if err != nil && !errors.Is(err, status.ErrInvalidTransition) {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+func (s *DeadLetterService) restore(ctx context.Context, deadLetter *models.DeadLetter, err error) error {
+	if addErr := s.Add(ctx, deadLetter); addErr != nil {
+		return errors.Join(err, addErr)
+	}
+
+	return err
+}
+
+// Discard deletes the dead letter. ErrDeadLetterNotFound is returned when it
+// does not exist, which also makes concurrent requeues of the same payment
+// fail for all but one caller.
+func (s *DeadLetterService) Discard(ctx context.Context, correlationID string) error {
+	var removed *redis.IntCmd
+	_, err := s.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.HDel(ctx, deadLettersKey, correlationID)
+		pipe.ZRem(ctx, deadLettersIndexKey, correlationID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if removed.Val() == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	return nil
+}
+
+func (s *DeadLetterService) Count(ctx context.Context) (int64, error) {
+	return s.cache.ZCard(ctx, deadLettersIndexKey).Result()
+}
+
+func (s *DeadLetterService) Purge(ctx context.Context) error {
+	return s.cache.Del(ctx, deadLettersKey, deadLettersIndexKey).Err()
+}
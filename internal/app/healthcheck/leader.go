@@ -0,0 +1,88 @@
+package healthcheck
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	leaderTokenKey = "processor_health_leader_token"
+	healthTokenKey = "processors_health_token"
+)
+
+// acquireScript takes the leader lock when it is free, or renews it when this
+// instance already holds it. A new fencing token is issued on every
+// acquisition and returned on renewals; 0 means another instance leads.
+//
+// KEYS: leader lock, token counter
+// ARGV: instance id, lock ttl ms
+var acquireScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local owner, token = string.match(current, '^(.*)|(%d+)$')
+	if owner ~= ARGV[1] then
+		return 0
+	end
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(token)
+end
+
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. '|' .. token, 'PX', ARGV[2])
+return token
+`)
+
+// releaseScript drops the leader lock only when this instance holds it.
+//
+// KEYS: leader lock
+// ARGV: instance id, fencing token
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] .. '|' .. ARGV[2] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// writeHealthScript stores and publishes a health snapshot only when the
+// writer still holds the lock with its fencing token and no newer token wrote
+// a snapshot, so a leader that lost the lock mid-check cannot overwrite it.
+//
+// KEYS: leader lock, health snapshot, snapshot token
+// ARGV: instance id, fencing token, snapshot, snapshot ttl ms, channel
+var writeHealthScript = redis.NewScript(`
+local token = tonumber(ARGV[2])
+if redis.call('GET', KEYS[1]) ~= ARGV[1] .. '|' .. ARGV[2] then
+	return 0
+end
+if tonumber(redis.call('GET', KEYS[3]) or '0') > token then
+	return 0
+end
+
+redis.call('SET', KEYS[3], token)
+redis.call('SET', KEYS[2], ARGV[3], 'PX', ARGV[4])
+redis.call('PUBLISH', ARGV[5], ARGV[3])
+return 1
+`)
+
+// acquireLeadership returns this instance's fencing token, or 0 when another
+// instance holds the leader lock.
+func (s *HealthCheckService) acquireLeadership(ctx context.Context) (int64, error) {
+	return acquireScript.Run(ctx, s.cache, []string{leaderLockKey, leaderTokenKey},
+		s.instanceID, (3 * s.interval).Milliseconds()).Int64()
+}
+
+func (s *HealthCheckService) releaseLeadership(ctx context.Context, token int64) error {
+	return releaseScript.Run(ctx, s.cache, []string{leaderLockKey}, s.instanceID, token).Err()
+}
+
+// writeHealth reports whether the snapshot was accepted for the token.
+func (s *HealthCheckService) writeHealth(ctx context.Context, token int64, payload []byte) (bool, error) {
+	written, err := writeHealthScript.Run(ctx, s.cache, []string{leaderLockKey, processorsHealthKey, healthTokenKey},
+		s.instanceID, token, payload, healthSnapshotTTL.Milliseconds(), healthChannel).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return written == 1, nil
+}
@@ -2,126 +2,277 @@ package healthcheck
 
 import (
 	"context"
-	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/routing"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
 	"log"
-	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
-	"github.com/valyala/fasthttp"
 )
 
-type ProcessorsHealth struct {
-	Default  *models.HealthCheck
-	Fallback *models.HealthCheck
-}
+// ProcessorsHealth maps processor names to their last health check.
+type ProcessorsHealth map[string]*models.HealthCheck
 
 const (
 	leaderLockKey       = "processor_health_leader_lock"
 	processorsHealthKey = "processors_health_status"
-	leaderLockTTL       = 15 * time.Second
-	routineInterval     = 5 * time.Second
+	passiveHealthKey    = "processors_passive_health"
+	healthChannel       = "processors_health_updates"
+	healthSnapshotTTL   = 30 * time.Second
+
+	// Every instance publishes the health observed on its own payments each
+	// passiveInterval. Entries older than passiveMaxAge are left out of the
+	// ranking and removed after passiveExpireAfter.
+	passiveInterval    = 1 * time.Second
+	passiveMaxAge      = 10 * time.Second
+	passiveExpireAfter = 1 * time.Minute
+
+	resubscribeBackoff = 1 * time.Second
 )
 
 type HealthCheckService struct {
-	defaultUrl  string
-	fallbackUrl string
-	client      *fasthttp.Client
-	cache       *redis.Client
-	instanceID  string
-
-	healthMutex sync.RWMutex
-	processor   string
+	processors   []processor.Processor
+	cache        *redis.Client
+	instanceID   string
+	policy       routing.Policy
+	observations *routing.Observations
+	interval     time.Duration
+
+	// lastHealth is the last snapshot written while leader, used to keep a
+	// processor's previous result when its health check request fails.
+	lastHealth ProcessorsHealth
+
+	// subscribed is set while snapshots are pushed through healthChannel, the
+	// background routine only polls them otherwise.
+	subscribed atomic.Bool
+
+	// leaderToken is the fencing token of the leader lock while this instance
+	// holds it, 0 otherwise.
+	leaderToken int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	healthMutex  sync.RWMutex
+	activeHealth ProcessorsHealth
+	ranking      []string
 }
 
-func NewHealthCheckService(defaultUrl, fallbackUrl string, cache *redis.Client) *HealthCheckService {
-	service := &HealthCheckService{
-		defaultUrl:  defaultUrl,
-		fallbackUrl: fallbackUrl,
-		client:      &fasthttp.Client{MaxConnsPerHost: 10},
-		cache:       cache,
-		instanceID:  uuid.New().String(),
+func NewHealthCheckService(processors []processor.Processor, cache *redis.Client, policy routing.Policy, cfg config.HealthCheck) *HealthCheckService {
+	return &HealthCheckService{
+		processors:   processors,
+		cache:        cache,
+		instanceID:   uuid.New().String(),
+		policy:       policy,
+		observations: routing.NewObservations(),
+		interval:     cfg.HealthCheckInterval,
+		lastHealth:   ProcessorsHealth{},
 	}
+}
 
-	go service.backgroundRoutine()
-	return service
+// Start runs the health checks, the passive health publishing and the
+// subscription to the leader's snapshots until Shutdown.
+func (s *HealthCheckService) Start(ctx context.Context) {
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	s.wg.Add(3)
+	go s.backgroundRoutine()
+	go s.passiveRoutine()
+	go s.subscribeRoutine()
 }
 
-func (s *HealthCheckService) AvailableProcessor(ctx context.Context) string {
+// RankedProcessors returns the processors that may receive payments, from
+// best to worst.
+func (s *HealthCheckService) RankedProcessors(ctx context.Context) []string {
 	s.healthMutex.RLock()
-	processor := s.processor
-	s.healthMutex.RUnlock()
+	defer s.healthMutex.RUnlock()
+
+	return s.ranking
+}
+
+// Observe records the outcome of a real payment sent to a processor, used by
+// the routing policy together with the health checks.
+func (s *HealthCheckService) Observe(processor string, latency time.Duration, success bool) {
+	s.observations.Observe(processor, latency, success)
+}
+
+// Shutdown stops the background routines and releases the leader lock so the
+// other instance takes over health checks without waiting for it to expire.
+func (s *HealthCheckService) Shutdown(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if s.leaderToken == 0 {
+		return nil
+	}
 
-	return processor
+	metrics.HealthLeader.Set(0)
+	return s.releaseLeadership(ctx, s.leaderToken)
 }
 
 func (s *HealthCheckService) backgroundRoutine() {
-	ticker := time.NewTicker(routineInterval)
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		ctx := context.Background()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx := s.ctx
 
-		acquire, err := s.cache.SetNX(ctx, leaderLockKey, s.instanceID, leaderLockTTL).Result()
+		token, err := s.acquireLeadership(ctx)
 		if err != nil {
 			log.Printf("Error acquiring leader lock for instance %s: %v\n", s.instanceID, err)
 			continue
 		}
 
-		isLeader := acquire
-		if !isLeader {
-			currentLeader, err := s.cache.Get(ctx, leaderLockKey).Result()
-			if err == nil && currentLeader == s.instanceID {
-				isLeader = true
+		if token != s.leaderToken {
+			if token != 0 {
+				log.Printf("Instance %s is now health check leader with token %d\n", s.instanceID, token)
 			}
+			s.leaderToken = token
 		}
 
-		if isLeader {
-			s.performChecksAndUpdate(ctx)
+		if token != 0 {
+			metrics.HealthLeader.Set(1)
+			s.performChecksAndUpdate(ctx, token)
+		} else {
+			metrics.HealthLeader.Set(0)
+		}
 
-			if err := s.cache.Expire(ctx, leaderLockKey, leaderLockTTL).Err(); err != nil {
-				log.Printf("Error renewing leader lock: %v\n", err)
-			}
+		if !s.subscribed.Load() {
+			s.syncHealth(ctx)
 		}
+	}
+}
+
+// subscribeRoutine applies every snapshot published by the leader as soon as
+// it is written. While the subscription is down, backgroundRoutine polls the
+// snapshot instead.
+func (s *HealthCheckService) subscribeRoutine() {
+	defer s.wg.Done()
+
+	ctx := s.ctx
 
-		s.syncHealth(ctx)
+	for ctx.Err() == nil {
+		s.subscribe(ctx)
+
+		if ctx.Err() == nil {
+			waitResubscribe(ctx)
+		}
 	}
 }
 
-func (s *HealthCheckService) performChecksAndUpdate(ctx context.Context) {
-	var wg sync.WaitGroup
-	var defaultHealth, fallbackHealth *models.HealthCheck
-	var defaultErr, fallbackErr error
+// subscribe applies the snapshots published on healthChannel until the
+// subscription fails or ctx is done.
+func (s *HealthCheckService) subscribe(ctx context.Context) {
+	pubsub := s.cache.Subscribe(ctx, healthChannel)
+	defer pubsub.Close()
 
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		defaultHealth, defaultErr = s.checkHealth(s.defaultUrl)
-	}()
-	go func() {
-		defer wg.Done()
-		fallbackHealth, fallbackErr = s.checkHealth(s.fallbackUrl)
-	}()
-	wg.Wait()
+	// Receiving does not watch ctx, closing unblocks it on Shutdown
+	stop := context.AfterFunc(ctx, func() { pubsub.Close() })
+	defer stop()
 
-	if defaultErr != nil {
-		log.Println("Error checking default health:", defaultErr)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		if ctx.Err() == nil {
+			log.Println("Error subscribing to health updates:", err)
+		}
 		return
 	}
 
-	if fallbackErr != nil {
-		log.Println("Error checking fallback health:", fallbackErr)
-		return
+	s.subscribed.Store(true)
+	metrics.HealthSubscribed.Set(1)
+	defer func() {
+		s.subscribed.Store(false)
+		metrics.HealthSubscribed.Set(0)
+	}()
+
+	// Catch up with any snapshot published while not subscribed
+	s.syncHealth(ctx)
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println("Error receiving health update, falling back to polling:", err)
+			return
+		}
+
+		var healthStatus ProcessorsHealth
+		if err := sonic.ConfigFastest.UnmarshalFromString(msg.Payload, &healthStatus); err != nil {
+			log.Println("Error unmarshalling health update:", err)
+			continue
+		}
+
+		s.applyHealth(ctx, healthStatus)
 	}
+}
 
-	combinedHealth := &ProcessorsHealth{
-		Default:  defaultHealth,
-		Fallback: fallbackHealth,
+// waitResubscribe waits resubscribeBackoff, returning early on Shutdown so it
+// is not delayed by a subscription that is down.
+func waitResubscribe(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(resubscribeBackoff):
 	}
+}
+
+func (s *HealthCheckService) performChecksAndUpdate(ctx context.Context, token int64) {
+	var wg sync.WaitGroup
+	healths := make([]*models.HealthCheck, len(s.processors))
+	errs := make([]error, len(s.processors))
+
+	for i, p := range s.processors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healths[i], errs[i] = p.CheckHealth()
+		}()
+	}
+	wg.Wait()
+
+	combinedHealth := make(ProcessorsHealth, len(s.processors))
+	for i, p := range s.processors {
+		observeHealthCheck(p.Name(), healths[i], errs[i])
+
+		if errs[i] != nil {
+			log.Printf("Error checking %s health: %v\n", p.Name(), errs[i])
+			combinedHealth[p.Name()] = s.lastHealth[p.Name()]
+			continue
+		}
+
+		combinedHealth[p.Name()] = healths[i]
+	}
+	s.lastHealth = combinedHealth
 
 	payload, err := sonic.Marshal(combinedHealth)
 	if err != nil {
@@ -129,39 +280,29 @@ func (s *HealthCheckService) performChecksAndUpdate(ctx context.Context) {
 		return
 	}
 
-	if err := s.cache.Set(ctx, processorsHealthKey, payload, 30*time.Second).Err(); err != nil {
+	written, err := s.writeHealth(ctx, token, payload)
+	if err != nil {
 		log.Println("Error setting combined health in Redis:", err)
-	}
-}
-
-func (s *HealthCheckService) checkHealth(url string) (*models.HealthCheck, error) {
-	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
-	defer func() {
-		fasthttp.ReleaseRequest(req)
-		fasthttp.ReleaseResponse(resp)
-	}()
-
-	req.SetRequestURI(url + "/payments/service-health")
-	req.Header.SetMethod(http.MethodGet)
-	req.Header.SetContentType("application/json")
-
-	if err := s.client.DoTimeout(req, resp, 5*time.Second); err != nil {
-		return nil, fmt.Errorf("failed to make payment request: %w", err)
+		return
 	}
 
-	statusCode := resp.StatusCode()
-	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf("payment request failed with status code: %d", statusCode)
+	if !written {
+		log.Printf("Health snapshot with stale token %d rejected, instance %s lost leadership\n", token, s.instanceID)
 	}
+}
 
-	body := resp.Body()
-
-	var healthCheck models.HealthCheck
-	if err := sonic.Unmarshal(body, &healthCheck); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal health check response: %w", err)
+func observeHealthCheck(processor string, health *models.HealthCheck, err error) {
+	switch {
+	case err != nil:
+		metrics.HealthChecks.WithLabelValues(processor, "error").Inc()
+		return
+	case health.IsFailing:
+		metrics.HealthChecks.WithLabelValues(processor, "failing").Inc()
+	default:
+		metrics.HealthChecks.WithLabelValues(processor, "healthy").Inc()
 	}
 
-	return &healthCheck, nil
+	metrics.ProcessorMinResponseTime.WithLabelValues(processor).Set(float64(health.MinResponseTime) / 1000)
 }
 
 func (s *HealthCheckService) syncHealth(ctx context.Context) {
@@ -180,29 +321,164 @@ func (s *HealthCheckService) syncHealth(ctx context.Context) {
 		return
 	}
 
-	processor := s.calculateProcessor(healthStatus)
-	log.Printf("Calculated best processor: %s\n", processor)
+	s.applyHealth(ctx, healthStatus)
+}
 
+func (s *HealthCheckService) applyHealth(ctx context.Context, healthStatus ProcessorsHealth) {
 	s.healthMutex.Lock()
-	s.processor = processor
+	s.activeHealth = healthStatus
 	s.healthMutex.Unlock()
+
+	passive, err := s.passiveHealth(ctx)
+	if err != nil {
+		log.Println("Error getting passive health from Redis:", err)
+	}
+
+	ranking := s.calculateRanking(healthStatus, passive)
+	log.Printf("Calculated processors ranking: %v\n", ranking)
+
+	s.healthMutex.Lock()
+	s.ranking = ranking
+	s.healthMutex.Unlock()
+}
+
+// passiveRoutine publishes the health observed on this instance's payments
+// and re-ranks the processors with what every instance observed, between the
+// active health checks.
+func (s *HealthCheckService) passiveRoutine() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(passiveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx := s.ctx
+
+		if err := s.publishPassiveHealth(ctx); err != nil {
+			log.Println("Error publishing passive health to Redis:", err)
+		}
+
+		passive, err := s.passiveHealth(ctx)
+		if err != nil {
+			log.Println("Error getting passive health from Redis:", err)
+			continue
+		}
+
+		s.healthMutex.RLock()
+		activeHealth := s.activeHealth
+		s.healthMutex.RUnlock()
+
+		if activeHealth == nil {
+			continue
+		}
+
+		ranking := s.calculateRanking(activeHealth, passive)
+
+		s.healthMutex.Lock()
+		s.ranking = ranking
+		s.healthMutex.Unlock()
+	}
+}
+
+func (s *HealthCheckService) publishPassiveHealth(ctx context.Context) error {
+	snapshot := s.observations.Snapshot()
+	if len(snapshot) == 0 {
+		return nil
+	}
+
+	values := make([]any, 0, len(snapshot)*2)
+	for processor, health := range snapshot {
+		payload, err := sonic.ConfigFastest.Marshal(health)
+		if err != nil {
+			return err
+		}
+
+		values = append(values, s.passiveField(processor), payload)
+	}
+
+	return s.cache.HSet(ctx, passiveHealthKey, values...).Err()
 }
 
-func (s *HealthCheckService) calculateProcessor(healthStatus ProcessorsHealth) string {
-	defaultHealth := healthStatus.Default
-	fallbackHealth := healthStatus.Fallback
+// passiveHealth merges the fresh passive health published by every instance,
+// removing the entries of instances that stopped publishing.
+func (s *HealthCheckService) passiveHealth(ctx context.Context) (map[string]models.PassiveHealth, error) {
+	fields, err := s.cache.HGetAll(ctx, passiveHealthKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make(map[string][]models.PassiveHealth)
+	var stale []string
+
+	for field, payload := range fields {
+		processor, ok := passiveProcessor(field)
+		if !ok {
+			stale = append(stale, field)
+			continue
+		}
+
+		var health models.PassiveHealth
+		if err := sonic.ConfigFastest.UnmarshalFromString(payload, &health); err != nil {
+			stale = append(stale, field)
+			continue
+		}
 
-	if defaultHealth != nil && !defaultHealth.IsFailing && defaultHealth.MinResponseTime <= 300 {
-		return "default"
+		age := now.Sub(health.UpdatedAt)
+		if age > passiveExpireAfter {
+			stale = append(stale, field)
+		}
+		if age > passiveMaxAge {
+			continue
+		}
+
+		entries[processor] = append(entries[processor], health)
 	}
 
-	if fallbackHealth != nil && !fallbackHealth.IsFailing && defaultHealth.MinResponseTime <= 200 {
-		return "fallback"
+	if len(stale) > 0 {
+		if err := s.cache.HDel(ctx, passiveHealthKey, stale...).Err(); err != nil {
+			log.Println("Error removing stale passive health from Redis:", err)
+		}
+	}
+
+	passive := make(map[string]models.PassiveHealth, len(entries))
+	for processor, health := range entries {
+		if merged, ok := routing.MergePassiveHealth(health); ok {
+			passive[processor] = merged
+		}
 	}
 
-	if defaultHealth != nil && !defaultHealth.IsFailing {
-		return "default"
+	return passive, nil
+}
+
+func (s *HealthCheckService) passiveField(processor string) string {
+	return s.instanceID + "|" + processor
+}
+
+func passiveProcessor(field string) (string, bool) {
+	_, processor, ok := strings.Cut(field, "|")
+	return processor, ok
+}
+
+func (s *HealthCheckService) calculateRanking(healthStatus ProcessorsHealth, passive map[string]models.PassiveHealth) []string {
+	candidates := make([]routing.Candidate, 0, len(s.processors))
+	for _, p := range s.processors {
+		candidate := routing.Candidate{
+			Name:     p.Name(),
+			Fee:      p.Fee(),
+			Priority: p.Priority(),
+			Health:   healthStatus[p.Name()],
+		}
+		routing.Apply(&candidate, passive)
+
+		candidates = append(candidates, candidate)
 	}
 
-	return ""
+	return s.policy.Rank(candidates)
 }
@@ -0,0 +1,115 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const reservationKeyPrefix = "idempotency:"
+
+type Result int
+
+const (
+	// Reserved means the correlation ID was not seen before and the caller
+	// now owns it.
+	Reserved Result = iota
+	// InFlight means the same payment was already accepted and is still
+	// being processed.
+	InFlight
+	// Completed means the same payment was already processed and stored.
+	Completed
+	// Conflict means the correlation ID was already used with another amount.
+	Conflict
+	// Failed and Abandoned mean the same payment was already accepted but
+	// failed or ran out of retries. It stays dead-lettered until requeued.
+	Failed
+	Abandoned
+)
+
+type IdempotencyService struct {
+	cache          *redis.Client
+	storageService *storage.StorageService
+	statusService  *status.StatusService
+	ttl            time.Duration
+}
+
+func NewIdempotencyService(cache *redis.Client, storageService *storage.StorageService, statusService *status.StatusService, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		cache:          cache,
+		storageService: storageService,
+		statusService:  statusService,
+		ttl:            ttl,
+	}
+}
+
+// Reserve atomically claims the payment correlation ID. When the ID is already
+// taken the returned result tells how the duplicate should be answered, and
+// for completed payments the stored record is returned as well.
+func (s *IdempotencyService) Reserve(ctx context.Context, payment *models.Payment) (Result, *models.Payment, error) {
+	amount := payment.Amount.String()
+
+	previous, err := s.cache.SetArgs(ctx, reservationKey(payment.CorrelationID), amount, redis.SetArgs{
+		Mode: "NX",
+		TTL:  s.ttl,
+		Get:  true,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return Reserved, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if previous != amount {
+		return Conflict, nil, nil
+	}
+
+	stored, err := s.storageService.GetPayment(ctx, payment.CorrelationID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if stored != nil {
+		return Completed, stored, nil
+	}
+
+	// The reservation outlives payments that failed, so their duplicates are
+	// answered with how they ended rather than as still in flight
+	current, err := s.statusService.GetStatus(ctx, payment.CorrelationID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if current != nil {
+		switch current.Status {
+		case models.PaymentFailed:
+			return Failed, nil, nil
+		case models.PaymentAbandoned:
+			return Abandoned, nil, nil
+		}
+	}
+
+	return InFlight, nil, nil
+}
+
+// Release drops a reservation so the payment can be submitted again, used
+// when it could not be accepted after being reserved.
+func (s *IdempotencyService) Release(ctx context.Context, correlationID string) error {
+	return s.cache.Del(ctx, reservationKey(correlationID)).Err()
+}
+
+func (s *IdempotencyService) Purge(ctx context.Context) error {
+	return storage.DeleteByPattern(ctx, s.cache, reservationKeyPrefix+"*")
+}
+
+func (s *IdempotencyService) Count(ctx context.Context) (int64, error) {
+	return storage.CountByPattern(ctx, s.cache, reservationKeyPrefix+"*")
+}
+
+func reservationKey(correlationID string) string {
+	return reservationKeyPrefix + correlationID
+}
@@ -0,0 +1,85 @@
+package loadgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage ramps the arrival rate linearly to Target payments per second over
+// Duration.
+type Stage struct {
+	Duration time.Duration
+	Target   float64
+}
+
+// Curve is a payment arrival rate over time, starting at Start payments per
+// second and following its stages in order.
+type Curve struct {
+	Start  float64
+	Stages []Stage
+}
+
+// ParseStages parses stages written as "duration:rate" separated by commas,
+// e.g. "10s:100,1m:500,10s:0".
+func ParseStages(value string) ([]Stage, error) {
+	var stages []Stage
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		durationValue, targetValue, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid stage %q, expected duration:rate", entry)
+		}
+
+		duration, err := time.ParseDuration(durationValue)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid stage %q duration", entry)
+		}
+
+		target, err := strconv.ParseFloat(targetValue, 64)
+		if err != nil || target < 0 {
+			return nil, fmt.Errorf("invalid stage %q rate", entry)
+		}
+
+		stages = append(stages, Stage{Duration: duration, Target: target})
+	}
+
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("no stages")
+	}
+
+	return stages, nil
+}
+
+func (c Curve) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range c.Stages {
+		total += stage.Duration
+	}
+
+	return total
+}
+
+// Rate returns the arrival rate, in payments per second, elapsed after the
+// start of the curve.
+func (c Curve) Rate(elapsed time.Duration) float64 {
+	from := c.Start
+
+	for _, stage := range c.Stages {
+		if elapsed < stage.Duration {
+			progress := float64(elapsed) / float64(stage.Duration)
+			return from + (stage.Target-from)*progress
+		}
+
+		elapsed -= stage.Duration
+		from = stage.Target
+	}
+
+	return 0
+}
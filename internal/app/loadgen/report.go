@@ -0,0 +1,190 @@
+package loadgen
+
+import (
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Scoring mirrors the Rinha de Backend 2025 rules: the net amount earned gets
+// a bonus for every millisecond the p99 stays under p99BonusThreshold, and a
+// penalty when any inconsistency was found.
+const (
+	p99BonusThreshold    = 11 * time.Millisecond
+	p99BonusPerMs        = 0.02
+	inconsistencyPenalty = 0.35
+)
+
+// Check is a comparison of the payments summary with the payments sent.
+type Check struct {
+	At       time.Time    `json:"at"`
+	Requests int          `json:"requests"`
+	Amount   models.Money `json:"amount"`
+	// MinRequests and MaxRequests bound the requests the summary may count.
+	MinRequests int64  `json:"minRequests"`
+	MaxRequests int64  `json:"maxRequests"`
+	Consistent  bool   `json:"consistent"`
+	Final       bool   `json:"final"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+func newCheck(summary models.PaymentsSummary, maxRequests, minRequests int64, amount models.Money) Check {
+	check := Check{
+		At:          time.Now().UTC(),
+		MinRequests: minRequests,
+		MaxRequests: maxRequests,
+	}
+
+	for _, processor := range summary {
+		check.Requests += processor.TotalRequests
+		check.Amount += processor.TotalAmount
+	}
+
+	requests := int64(check.Requests)
+	switch {
+	case requests > maxRequests:
+		check.Detail = fmt.Sprintf("summary has %d payments, more than the %d sent", requests, maxRequests)
+	case requests < minRequests:
+		check.Detail = fmt.Sprintf("summary has %d payments, missing %d accepted", requests, minRequests-requests)
+	case check.Amount != amount*models.Money(check.Requests):
+		check.Detail = fmt.Sprintf("summary amount %s does not match %d payments of %s", check.Amount, requests, amount)
+	default:
+		check.Consistent = true
+	}
+
+	return check
+}
+
+type Report struct {
+	Duration  time.Duration `json:"duration"`
+	Sent      int64         `json:"sent"`
+	Accepted  int64         `json:"accepted"`
+	Rejected  int64         `json:"rejected"`
+	TimedOut  int64         `json:"timedOut"`
+	Dropped   int64         `json:"dropped"`
+	Processed int           `json:"processed"`
+
+	// Throughput is the accepted payments per second.
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50"`
+	P99        time.Duration `json:"p99"`
+
+	Inconsistencies int     `json:"inconsistencies"`
+	Checks          []Check `json:"checks"`
+
+	Processors    models.PaymentsSummary `json:"processors"`
+	FallbackRatio float64                `json:"fallbackRatio"`
+	Amount        models.Money           `json:"amount"`
+	Fees          models.Money           `json:"fees"`
+	Score         float64                `json:"score"`
+}
+
+func (r *Runner) report(duration time.Duration, final Check, summary models.PaymentsSummary) *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := &Report{
+		Duration:  duration,
+		Sent:      r.sent.Load(),
+		Accepted:  r.accepted.Load(),
+		Rejected:  r.rejected.Load(),
+		TimedOut:  r.ambiguous.Load(),
+		Dropped:   r.dropped.Load(),
+		Processed: final.Requests,
+		Checks:    r.checks,
+	}
+
+	if duration > 0 {
+		report.Throughput = float64(report.Accepted) / duration.Seconds()
+	}
+
+	latencies := slices.Clone(r.latencies)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = quantile(latencies, 0.5)
+	report.P99 = quantile(latencies, 0.99)
+
+	for _, check := range r.checks {
+		if !check.Consistent {
+			report.Inconsistencies++
+		}
+	}
+
+	report.summarize(summary, r.cfg.DefaultProcessor, r.cfg.Fees)
+
+	return report
+}
+
+// summarize fills in the processors figures and the score from the final
+// payments summary.
+func (report *Report) summarize(summary models.PaymentsSummary, defaultProcessor string, fees map[string]float64) {
+	report.Processors = summary
+
+	var total, fallback int
+	var fee float64
+	for name, processor := range summary {
+		total += processor.TotalRequests
+		if name != defaultProcessor {
+			fallback += processor.TotalRequests
+		}
+
+		report.Amount += processor.TotalAmount
+		fee += float64(processor.TotalAmount) * fees[name]
+	}
+	report.Fees = models.Money(math.Round(fee))
+
+	if total > 0 {
+		report.FallbackRatio = float64(fallback) / float64(total)
+	}
+
+	score := float64(report.Amount-report.Fees) / 100
+	if bonus := p99BonusThreshold - report.P99; bonus > 0 {
+		score *= 1 + float64(bonus.Milliseconds())*p99BonusPerMs
+	}
+	if report.Inconsistencies > 0 {
+		score *= 1 - inconsistencyPenalty
+	}
+	report.Score = score
+}
+
+func (report *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "duration         %s\n", report.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "payments         %d sent, %d accepted, %d rejected, %d timed out, %d dropped\n",
+		report.Sent, report.Accepted, report.Rejected, report.TimedOut, report.Dropped)
+	fmt.Fprintf(w, "processed        %d\n", report.Processed)
+	fmt.Fprintf(w, "throughput       %.1f payments/s\n", report.Throughput)
+	fmt.Fprintf(w, "latency          p50 %s, p99 %s\n", report.P50, report.P99)
+	fmt.Fprintf(w, "inconsistencies  %d of %d checks\n", report.Inconsistencies, len(report.Checks))
+	for _, check := range report.Checks {
+		if !check.Consistent {
+			fmt.Fprintf(w, "                 %s: %s\n", check.At.Format(time.RFC3339), check.Detail)
+		}
+	}
+
+	names := make([]string, 0, len(report.Processors))
+	for name := range report.Processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		processor := report.Processors[name]
+		fmt.Fprintf(w, "%-16s %d payments, %s\n", name, processor.TotalRequests, processor.TotalAmount)
+	}
+
+	fmt.Fprintf(w, "fallback ratio   %.2f%%\n", report.FallbackRatio*100)
+	fmt.Fprintf(w, "fees             %s of %s\n", report.Fees, report.Amount)
+	fmt.Fprintf(w, "score            %.2f\n", report.Score)
+}
+
+// quantile returns the q quantile of sorted values.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	index := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(index, 0)]
+}
@@ -0,0 +1,275 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+// dispatchInterval is how often the runner sends the payments due by the
+// arrival curve.
+const dispatchInterval = 5 * time.Millisecond
+
+// paymentRequest is the body of POST /payments.
+type paymentRequest struct {
+	CorrelationID string       `json:"correlationId"`
+	Amount        models.Money `json:"amount"`
+}
+
+type Config struct {
+	// BaseURL is the server (or load balancer) under test.
+	BaseURL string
+	Curve   Curve
+	Amount  models.Money
+	// MaxInFlight bounds the concurrent requests, payments due while every
+	// request is in flight are dropped and reported.
+	MaxInFlight int
+	Timeout     time.Duration
+	// CheckInterval is how often /payments-summary is checked during the
+	// run. Drain is how long the final check waits for queued payments to
+	// be processed.
+	CheckInterval time.Duration
+	Drain         time.Duration
+	// DefaultProcessor is the processor payments should go to, the others
+	// count as fallbacks. Fees maps processors to the fraction they charge.
+	DefaultProcessor string
+	Fees             map[string]float64
+}
+
+// Runner replays an arrival curve of payments against the server and checks
+// its payments summary against what was sent.
+type Runner struct {
+	cfg    Config
+	client *fasthttp.Client
+
+	sent      atomic.Int64
+	accepted  atomic.Int64
+	rejected  atomic.Int64
+	ambiguous atomic.Int64
+	dropped   atomic.Int64
+
+	mutex     sync.Mutex
+	latencies []time.Duration
+	checks    []Check
+}
+
+func NewRunner(cfg Config) *Runner {
+	return &Runner{
+		cfg: cfg,
+		client: &fasthttp.Client{
+			MaxConnsPerHost: cfg.MaxInFlight,
+			ReadTimeout:     cfg.Timeout,
+			WriteTimeout:    cfg.Timeout,
+		},
+	}
+}
+
+// Run sends the payments of the curve, waits for them to be processed and
+// returns the report. Summaries are only read from the start of the run, so
+// the server does not need to be purged first.
+func (r *Runner) Run(ctx context.Context) (*Report, error) {
+	start := time.Now().UTC()
+
+	jobs := make(chan struct{}, r.cfg.MaxInFlight)
+	var workers sync.WaitGroup
+	for range r.cfg.MaxInFlight {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for range jobs {
+				r.sendPayment()
+			}
+		}()
+	}
+
+	checkCtx, stopChecks := context.WithCancel(ctx)
+	var checks sync.WaitGroup
+	checks.Add(1)
+	go func() {
+		defer checks.Done()
+		r.checkRoutine(checkCtx, start)
+	}()
+
+	r.dispatch(ctx, start, jobs)
+	close(jobs)
+	workers.Wait()
+
+	stopChecks()
+	checks.Wait()
+
+	duration := time.Since(start)
+	final, summary, err := r.finalCheck(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.report(duration, final, summary), nil
+}
+
+// dispatch sends the payments due by the curve, accumulating its rate so
+// fractional payments are carried over to the next interval.
+func (r *Runner) dispatch(ctx context.Context, start time.Time, jobs chan<- struct{}) {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	total := r.cfg.Curve.Duration()
+	last := time.Duration(0)
+	due := 0.0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		elapsed := min(time.Since(start), total)
+		due += r.cfg.Curve.Rate(elapsed) * (elapsed - last).Seconds()
+		last = elapsed
+
+		for ; due >= 1; due-- {
+			select {
+			case jobs <- struct{}{}:
+			default:
+				r.dropped.Add(1)
+			}
+		}
+
+		if elapsed >= total {
+			return
+		}
+	}
+}
+
+func (r *Runner) sendPayment() {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	payload, err := sonic.Marshal(&paymentRequest{
+		CorrelationID: uuid.New().String(),
+		Amount:        r.cfg.Amount,
+	})
+	if err != nil {
+		log.Printf("Error encoding payment: %v\n", err)
+		return
+	}
+
+	req.SetRequestURI(r.cfg.BaseURL + "/payments")
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(payload)
+
+	r.sent.Add(1)
+	started := time.Now()
+	err = r.client.DoTimeout(req, resp, r.cfg.Timeout)
+	latency := time.Since(started)
+
+	r.mutex.Lock()
+	r.latencies = append(r.latencies, latency)
+	r.mutex.Unlock()
+
+	switch {
+	case err != nil:
+		// The server may have accepted the payment before the timeout
+		r.ambiguous.Add(1)
+	case resp.StatusCode() >= 200 && resp.StatusCode() < 300:
+		r.accepted.Add(1)
+	default:
+		r.rejected.Add(1)
+	}
+}
+
+func (r *Runner) checkRoutine(ctx context.Context, start time.Time) {
+	ticker := time.NewTicker(r.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Payments are counted as sent before their request, so the summary
+		// may never have more of them
+		sent := r.sent.Load()
+		summary, err := r.summary(start)
+		if err != nil {
+			log.Printf("Error checking payments summary: %v\n", err)
+			continue
+		}
+
+		r.record(newCheck(summary, sent, 0, r.cfg.Amount))
+	}
+}
+
+// finalCheck waits up to the drain timeout for the summary to account for
+// every accepted payment.
+func (r *Runner) finalCheck(ctx context.Context, start time.Time) (Check, models.PaymentsSummary, error) {
+	accepted, ambiguous := r.accepted.Load(), r.ambiguous.Load()
+	deadline := time.Now().Add(r.cfg.Drain)
+
+	for {
+		summary, err := r.summary(start)
+		if err != nil {
+			return Check{}, nil, fmt.Errorf("failed to get payments summary: %w", err)
+		}
+
+		check := newCheck(summary, accepted+ambiguous, accepted, r.cfg.Amount)
+		if check.Consistent || time.Now().After(deadline) || ctx.Err() != nil {
+			check.Final = true
+			r.record(check)
+			return check, summary, nil
+		}
+
+		time.Sleep(r.cfg.CheckInterval / 4)
+	}
+}
+
+func (r *Runner) summary(start time.Time) (models.PaymentsSummary, error) {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	query := url.Values{}
+	query.Set("from", start.Add(-time.Second).Format(time.RFC3339Nano))
+
+	req.SetRequestURI(r.cfg.BaseURL + "/payments-summary?" + query.Encode())
+	req.Header.SetMethod(http.MethodGet)
+
+	if err := r.client.DoTimeout(req, resp, r.cfg.Timeout); err != nil {
+		return nil, err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return nil, fmt.Errorf("payments summary request failed with status code: %d", statusCode)
+	}
+
+	var summary models.PaymentsSummary
+	if err := sonic.Unmarshal(resp.Body(), &summary); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+func (r *Runner) record(check Check) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.checks = append(r.checks, check)
+}
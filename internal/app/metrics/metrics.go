@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "rinha"
+
+var (
+	QueueCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payment_queue_capacity",
+		Help:      "Configured payment buffer size (PAYMENT_WORKERS_EVENTS_BUFFER_SIZE).",
+	})
+
+	PaymentsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_rejected_total",
+		Help:      "Payments rejected by POST /payments, by reason.",
+	}, []string{"reason"})
+
+	PaymentsSpilled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_spilled_total",
+		Help:      "Payments moved to Redis because an in-memory buffer was full, by source.",
+	}, []string{"source"})
+
+	ProcessorPaymentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processor_payment_duration_seconds",
+		Help:      "Latency of payment requests sent to the payment processors.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2, 5},
+	}, []string{"processor"})
+
+	ProcessorPaymentResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_payment_responses_total",
+		Help:      "Payment processor responses by status code, \"error\" when no response was received.",
+	}, []string{"processor", "status_code"})
+
+	PaymentRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_retries_total",
+		Help:      "Payment retries scheduled by the retry workers.",
+	})
+
+	PaymentsReconciled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_reconciled_total",
+		Help:      "Ambiguous payments found already charged by a processor instead of being retried, by processor.",
+	}, []string{"processor"})
+
+	PaymentRetryGiveUps = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_retry_give_ups_total",
+		Help:      "Payments abandoned after exhausting their retries.",
+	})
+
+	ReconciliationDiscrepancy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_discrepancy",
+		Help:      "Processor totals minus stored totals in the last reconciliation, by processor and field: requests or amount (in cents).",
+	}, []string{"processor", "field"})
+
+	HealthChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "health_checks_total",
+		Help:      "Processor health check results: healthy, failing or error.",
+	}, []string{"processor", "result"})
+
+	ProcessorMinResponseTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "processor_min_response_time_seconds",
+		Help:      "minResponseTime reported by the last processor health check.",
+	}, []string{"processor"})
+
+	HealthSubscribed = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "health_subscribed",
+		Help:      "Whether this instance receives health snapshots through pub/sub (1) or polls them (0).",
+	})
+
+	HealthLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "health_leader",
+		Help:      "1 when this instance holds the health check leader lock.",
+	})
+
+	CircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "Processor circuit breaker state: 0 closed, 1 half-open, 2 open.",
+	}, []string{"processor"})
+
+	StorageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_duration_seconds",
+		Help:      "Latency of Redis commands issued by the storage service, by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+	}, []string{"operation"})
+)
+
+// RegisterQueueDepth exposes the number of payments waiting in the queue,
+// read through depth on every scrape.
+func RegisterQueueDepth(depth func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payment_queue_depth",
+		Help:      "Payments waiting in the payment queue.",
+	}, depth)
+}
+
+func RegisterRetryDepth(depth func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payment_retries_scheduled",
+		Help:      "Payments waiting in the retry scheduler.",
+	}, depth)
+}
+
+// ObserveStorage records the duration of a storage operation started at
+// start, meant to be deferred.
+func ObserveStorage(operation string, start time.Time) {
+	StorageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,285 @@
+package payment
+
+import (
+	"context"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+const (
+	breakerKeyPrefix         = "circuit_breaker:"
+	breakerFailuresKeyPrefix = "circuit_breaker_failures:"
+)
+
+// recordScript applies a payment outcome to the breaker shared by every
+// instance and returns its state and the time (ms) it stays open until.
+//
+// KEYS: breaker hash, failures counter
+// ARGV: success (1/0), now ms, failure threshold, failure window ms, open timeout ms
+var recordScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state') or 'closed'
+local openUntil = tonumber(redis.call('HGET', KEYS[1], 'openUntil') or '0')
+local now = tonumber(ARGV[2])
+
+if state == 'open' and now >= openUntil then
+	state = 'half-open'
+end
+
+if ARGV[1] == '1' then
+	if state == 'half-open' then
+		redis.call('HSET', KEYS[1], 'state', 'closed', 'openUntil', 0, 'probes', 0)
+		redis.call('DEL', KEYS[2])
+		return {'closed', 0}
+	end
+	return {state, openUntil}
+end
+
+if state == 'half-open' then
+	openUntil = now + tonumber(ARGV[5])
+	redis.call('HSET', KEYS[1], 'state', 'open', 'openUntil', openUntil, 'probes', 0)
+	return {'open', openUntil}
+end
+
+if state == 'open' then
+	return {state, openUntil}
+end
+
+local failures = redis.call('INCR', KEYS[2])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[2], ARGV[4])
+end
+
+if failures >= tonumber(ARGV[3]) then
+	openUntil = now + tonumber(ARGV[5])
+	redis.call('HSET', KEYS[1], 'state', 'open', 'openUntil', openUntil, 'probes', 0)
+	redis.call('DEL', KEYS[2])
+	return {'open', openUntil}
+end
+
+return {'closed', 0}
+`)
+
+// probeScript moves an expired open breaker to half-open and hands out up to
+// the allowed number of probes. Probes handed out over a whole open timeout
+// without any outcome are considered lost and handed out again. It returns
+// whether the payment is allowed, the breaker state and the time (ms) it
+// stays open until.
+//
+// KEYS: breaker hash
+// ARGV: now ms, max probes, open timeout ms
+var probeScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state') or 'closed'
+local openUntil = tonumber(redis.call('HGET', KEYS[1], 'openUntil') or '0')
+local now = tonumber(ARGV[1])
+
+if state == 'closed' then
+	return {1, 'closed', 0}
+end
+
+if state == 'open' then
+	if now < openUntil then
+		return {0, 'open', openUntil}
+	end
+	redis.call('HSET', KEYS[1], 'state', 'half-open', 'probes', 0, 'halfOpenSince', now)
+end
+
+local since = tonumber(redis.call('HGET', KEYS[1], 'halfOpenSince') or '0')
+if now - since >= tonumber(ARGV[3]) then
+	redis.call('HSET', KEYS[1], 'probes', 0, 'halfOpenSince', now)
+end
+
+local probes = tonumber(redis.call('HGET', KEYS[1], 'probes') or '0')
+if probes >= tonumber(ARGV[2]) then
+	return {0, 'half-open', 0}
+end
+
+redis.call('HINCRBY', KEYS[1], 'probes', 1)
+return {1, 'half-open', 0}
+`)
+
+type breakerSnapshot struct {
+	state     BreakerState
+	openUntil time.Time
+	syncedAt  time.Time
+}
+
+// CircuitBreaker tracks, per processor, the outcome of real payments and
+// stops sending payments to a processor that keeps failing, regardless of
+// its last health check. The state lives in Redis so every instance trips and
+// recovers together; each instance caches it for syncInterval.
+type CircuitBreaker struct {
+	cache            *redis.Client
+	failureThreshold int
+	failureWindow    time.Duration
+	openTimeout      time.Duration
+	halfOpenProbes   int
+	syncInterval     time.Duration
+
+	mu        sync.Mutex
+	snapshots map[string]breakerSnapshot
+}
+
+func NewCircuitBreaker(cache *redis.Client, cfg config.Breaker) *CircuitBreaker {
+	return &CircuitBreaker{
+		cache:            cache,
+		failureThreshold: cfg.BreakerFailureThreshold,
+		failureWindow:    cfg.BreakerFailureWindow,
+		openTimeout:      cfg.BreakerOpenTimeout,
+		halfOpenProbes:   cfg.BreakerHalfOpenProbes,
+		syncInterval:     cfg.BreakerSyncInterval,
+		snapshots:        make(map[string]breakerSnapshot),
+	}
+}
+
+// Allow reports whether a payment may be sent to the processor. While the
+// breaker is half-open only a limited number of probe payments are allowed.
+func (b *CircuitBreaker) Allow(ctx context.Context, processor string) bool {
+	snapshot := b.snapshot(ctx, processor)
+	now := time.Now()
+
+	if snapshot.state == BreakerClosed {
+		return true
+	}
+	if snapshot.state == BreakerOpen && now.Before(snapshot.openUntil) {
+		return false
+	}
+
+	result, err := probeScript.Run(ctx, b.cache, []string{breakerKey(processor)},
+		now.UnixMilli(), b.halfOpenProbes, b.openTimeout.Milliseconds()).Slice()
+	if err != nil {
+		log.Printf("Error acquiring circuit breaker probe for %s: %v\n", processor, err)
+		return false
+	}
+	if len(result) != 3 {
+		return false
+	}
+
+	// Another instance may have closed or reopened the breaker meanwhile
+	state, openUntil := parseBreakerResult(result[1:])
+	b.update(processor, state, openUntil)
+
+	allowed, _ := result[0].(int64)
+	return allowed == 1
+}
+
+// Record feeds the outcome of a payment sent to the processor. Successes
+// while closed are not sent to Redis, they cannot change the state.
+func (b *CircuitBreaker) Record(ctx context.Context, processor string, success bool) {
+	if success && b.snapshot(ctx, processor).state == BreakerClosed {
+		return
+	}
+
+	successArg := "0"
+	if success {
+		successArg = "1"
+	}
+
+	keys := []string{breakerKey(processor), breakerFailuresKey(processor)}
+	result, err := recordScript.Run(ctx, b.cache, keys,
+		successArg, time.Now().UnixMilli(), b.failureThreshold, b.failureWindow.Milliseconds(), b.openTimeout.Milliseconds()).Slice()
+	if err != nil {
+		log.Printf("Error recording circuit breaker outcome for %s: %v\n", processor, err)
+		return
+	}
+
+	state, openUntil := parseBreakerResult(result)
+	if state == BreakerOpen && b.snapshot(ctx, processor).state != BreakerOpen {
+		log.Printf("Circuit breaker for %s opened until %s\n", processor, openUntil.Format(time.RFC3339Nano))
+	}
+
+	b.update(processor, state, openUntil)
+}
+
+func (b *CircuitBreaker) snapshot(ctx context.Context, processor string) breakerSnapshot {
+	b.mu.Lock()
+	cached, ok := b.snapshots[processor]
+	b.mu.Unlock()
+
+	if ok && time.Since(cached.syncedAt) < b.syncInterval {
+		return cached
+	}
+
+	fields, err := b.cache.HMGet(ctx, breakerKey(processor), "state", "openUntil").Result()
+	if err != nil {
+		log.Printf("Error reading circuit breaker for %s: %v\n", processor, err)
+		if ok {
+			return cached
+		}
+		return breakerSnapshot{state: BreakerClosed}
+	}
+
+	state := BreakerClosed
+	if value, ok := fields[0].(string); ok {
+		state = BreakerState(value)
+	}
+
+	var openUntil time.Time
+	if value, ok := fields[1].(string); ok {
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			openUntil = time.UnixMilli(ms)
+		}
+	}
+
+	return b.update(processor, state, openUntil)
+}
+
+func (b *CircuitBreaker) update(processor string, state BreakerState, openUntil time.Time) breakerSnapshot {
+	snapshot := breakerSnapshot{
+		state:     state,
+		openUntil: openUntil,
+		syncedAt:  time.Now(),
+	}
+
+	b.mu.Lock()
+	b.snapshots[processor] = snapshot
+	b.mu.Unlock()
+
+	switch state {
+	case BreakerOpen:
+		metrics.CircuitBreakerState.WithLabelValues(processor).Set(2)
+	case BreakerHalfOpen:
+		metrics.CircuitBreakerState.WithLabelValues(processor).Set(1)
+	default:
+		metrics.CircuitBreakerState.WithLabelValues(processor).Set(0)
+	}
+
+	return snapshot
+}
+
+func parseBreakerResult(result []any) (BreakerState, time.Time) {
+	state := BreakerClosed
+	var openUntil time.Time
+
+	if len(result) == 2 {
+		if value, ok := result[0].(string); ok {
+			state = BreakerState(value)
+		}
+		if ms, ok := result[1].(int64); ok && ms > 0 {
+			openUntil = time.UnixMilli(ms)
+		}
+	}
+
+	return state, openUntil
+}
+
+func breakerKey(processor string) string {
+	return breakerKeyPrefix + processor
+}
+
+func breakerFailuresKey(processor string) string {
+	return breakerFailuresKeyPrefix + processor
+}
@@ -0,0 +1,149 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/valyala/fasthttp"
+)
+
+type ErrorClass string
+
+const (
+	// Retryable failures certainly did not charge the payment.
+	Retryable ErrorClass = "retryable"
+	// NonRetryable failures are rejections of the payment itself, retrying
+	// them gives the same answer.
+	NonRetryable ErrorClass = "non_retryable"
+	// Ambiguous failures happened after the request was sent, e.g. timeouts,
+	// so the processor may have charged the payment anyway.
+	Ambiguous ErrorClass = "ambiguous"
+)
+
+// PaymentError is a failed attempt to send a payment to a processor.
+type PaymentError struct {
+	Class      ErrorClass
+	Processor  string
+	StatusCode int
+	Err        error
+}
+
+func (e *PaymentError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("payment request failed with status code: %d, in processor: %s", e.StatusCode, e.Processor)
+	}
+
+	return fmt.Sprintf("failed to make payment request in processor %s: %v", e.Processor, e.Err)
+}
+
+func (e *PaymentError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the class of an error returned by MakePayment.
+func Classify(err error) ErrorClass {
+	if errors.Is(err, ErrNoAvailableProcessor) {
+		return Retryable
+	}
+
+	var paymentErr *PaymentError
+	if errors.As(err, &paymentErr) {
+		return paymentErr.Class
+	}
+
+	return NonRetryable
+}
+
+// AmbiguousProcessor returns the processor that may have charged a payment
+// that failed with err.
+func AmbiguousProcessor(err error) (string, bool) {
+	var paymentErr *PaymentError
+	if errors.As(err, &paymentErr) && paymentErr.Class == Ambiguous {
+		return paymentErr.Processor, true
+	}
+
+	return "", false
+}
+
+// RetryPolicy decides which failed payments are retried.
+type RetryPolicy struct {
+	retryableStatusCodes map[int]bool
+	retryAmbiguous       bool
+}
+
+func NewRetryPolicy(cfg config.Retry) (*RetryPolicy, error) {
+	policy := &RetryPolicy{
+		retryableStatusCodes: make(map[int]bool, len(cfg.RetryStatusCodes)),
+	}
+
+	for _, code := range cfg.RetryStatusCodes {
+		policy.retryableStatusCodes[code] = true
+	}
+
+	switch cfg.RetryAmbiguous {
+	case "retry":
+		policy.retryAmbiguous = true
+	case "deadletter":
+		policy.retryAmbiguous = false
+	default:
+		return nil, fmt.Errorf("unknown ambiguous retry policy: %s", cfg.RetryAmbiguous)
+	}
+
+	return policy, nil
+}
+
+// ShouldRetry reports whether a payment that failed with err is retried.
+func (p *RetryPolicy) ShouldRetry(err error) bool {
+	switch Classify(err) {
+	case Retryable:
+		return true
+	case Ambiguous:
+		return p.retryAmbiguous
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) classifyStatus(statusCode int) ErrorClass {
+	if p.retryableStatusCodes[statusCode] {
+		return Retryable
+	}
+
+	// The processor timed out on its side, the payment may have gone through
+	if statusCode == http.StatusGatewayTimeout {
+		return Ambiguous
+	}
+
+	return NonRetryable
+}
+
+// classifyTransport tells failures to reach the processor, which are safe to
+// retry, from failures after the request was sent.
+func classifyTransport(err error) ErrorClass {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, fasthttp.ErrDialTimeout),
+		errors.Is(err, fasthttp.ErrNoFreeConns),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.As(err, &opErr) && opErr.Op == "dial":
+		return Retryable
+	case errors.Is(err, fasthttp.ErrTimeout),
+		errors.Is(err, fasthttp.ErrConnectionClosed),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return Ambiguous
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return Ambiguous
+	}
+
+	return NonRetryable
+}
@@ -2,14 +2,15 @@ package payment
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/healthcheck"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
 	"net/http"
+	"strconv"
 	"time"
-
-	"github.com/bytedance/sonic"
-	"github.com/valyala/fasthttp"
 )
 
 var (
@@ -18,73 +19,137 @@ var (
 )
 
 type PaymentService struct {
-	defaultUrl  string
-	fallbackUrl string
-	client      *fasthttp.Client
+	processors  map[string]processor.Processor
+	names       []string
+	breaker     *CircuitBreaker
+	retryPolicy *RetryPolicy
 
 	HealthCheckService *healthcheck.HealthCheckService
 }
 
-func NewPaymentService(defaultHost, fallbackHost string, healthCheckService *healthcheck.HealthCheckService) *PaymentService {
-	client := &fasthttp.Client{
-		MaxConnsPerHost: 1000,
+func NewPaymentService(processors []processor.Processor, breaker *CircuitBreaker, retryPolicy *RetryPolicy, healthCheckService *healthcheck.HealthCheckService) *PaymentService {
+	byName := make(map[string]processor.Processor, len(processors))
+	for _, p := range processors {
+		byName[p.Name()] = p
 	}
 
 	return &PaymentService{
-		defaultUrl:         defaultHost + "/payments",
-		fallbackUrl:        fallbackHost + "/payments",
-		client:             client,
+		processors:         byName,
+		names:              processor.Names(processors),
+		breaker:            breaker,
+		retryPolicy:        retryPolicy,
 		HealthCheckService: healthCheckService,
 	}
 }
 
-func (p *PaymentService) MakePayment(ctx context.Context, payment *models.Payment) error {
-	processor := p.HealthCheckService.AvailableProcessor(ctx)
-	payment.ProcessingType = processor
+// MakePayment sends the payment to the best ranked processor whose circuit
+// breaker allows it. The returned attempt describes the call made to the
+// processor and is nil when no processor was available.
+func (p *PaymentService) MakePayment(ctx context.Context, payment *models.Payment) (*models.PaymentAttempt, error) {
+	for _, name := range p.HealthCheckService.RankedProcessors(ctx) {
+		selected, ok := p.processors[name]
+		if !ok || !p.breaker.Allow(ctx, name) {
+			continue
+		}
+
+		payment.ProcessingType = name
+		attempt, err := p.innerPayment(selected, payment)
+		p.breaker.Record(ctx, name, processorHealthy(err))
 
-	switch processor {
-	case "default":
-		return p.innerPayment(p.defaultUrl, payment)
-	case "fallback":
-		return p.innerPayment(p.fallbackUrl, payment)
+		return attempt, err
 	}
 
-	return ErrNoAvailableProcessor
+	payment.ProcessingType = ""
+	return nil, ErrNoAvailableProcessor
 }
 
-func (p *PaymentService) innerPayment(url string, payment *models.Payment) error {
-	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
-	defer func() {
-		fasthttp.ReleaseRequest(req)
-		fasthttp.ReleaseResponse(resp)
-	}()
+// LookupPayment asks the processors, in order, whether they recorded the
+// payment, e.g. after an attempt that timed out. The payment found is returned
+// with ProcessingType set to the processor that has it; nil is returned only
+// when every processor answered that it does not have it.
+func (p *PaymentService) LookupPayment(ctx context.Context, correlationID string, processors []string) (*models.Payment, error) {
+	var errs []error
+
+	for _, name := range processors {
+		selected, ok := p.processors[name]
+		if !ok {
+			continue
+		}
 
-	payment.RequestedAt = time.Now().UTC()
-	payload, err := sonic.Marshal(payment)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payment: %w", err)
+		found, err := selected.LookupPayment(correlationID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("processor %s: %w", name, err))
+			continue
+		}
+
+		if found != nil {
+			found.ProcessingType = name
+			return found, nil
+		}
 	}
 
-	req.SetRequestURI(url)
-	req.Header.SetMethod(http.MethodPost)
-	req.Header.SetContentType("application/json")
-	req.SetBody(payload)
+	return nil, errors.Join(errs...)
+}
+
+// Processors returns the names of every configured processor.
+func (p *PaymentService) Processors() []string {
+	return p.names
+}
 
-	if err := p.client.DoTimeout(req, resp, 2*time.Second); err != nil {
-		return fmt.Errorf("failed to make payment request in processor %s: %w", payment.ProcessingType, err)
+// ShouldRetry reports whether a payment that failed with err is retried,
+// according to the configured retry policy.
+func (p *PaymentService) ShouldRetry(err error) bool {
+	return p.retryPolicy.ShouldRetry(err)
+}
+
+// processorHealthy reports whether the outcome of a payment says the
+// processor works: rejections of the payment itself (e.g. 4xx) say nothing
+// about its health, only retryable and ambiguous failures count against it.
+func processorHealthy(err error) bool {
+	return err == nil || Classify(err) == NonRetryable
+}
+
+func (p *PaymentService) innerPayment(selected processor.Processor, payment *models.Payment) (*models.PaymentAttempt, error) {
+	payment.RequestedAt = time.Now().UTC()
+
+	attempt := &models.PaymentAttempt{
+		Processor:   payment.ProcessingType,
+		AttemptedAt: payment.RequestedAt,
 	}
 
-	statusCode := resp.StatusCode()
-	if statusCode != http.StatusOK {
-		if statusCode == http.StatusInternalServerError ||
-			statusCode == http.StatusRequestTimeout ||
-			statusCode == http.StatusTooManyRequests ||
-			statusCode == http.StatusServiceUnavailable {
-			return ErrPaymentProcessingFailed
+	statusCode, err := selected.SendPayment(payment)
+	latency := time.Since(payment.RequestedAt)
+	attempt.LatencyMs = latency.Milliseconds()
+	metrics.ProcessorPaymentDuration.WithLabelValues(payment.ProcessingType).Observe(latency.Seconds())
+
+	if err != nil {
+		metrics.ProcessorPaymentResponses.WithLabelValues(payment.ProcessingType, "error").Inc()
+
+		err = &PaymentError{
+			Class:     classifyTransport(err),
+			Processor: payment.ProcessingType,
+			Err:       err,
+		}
+	} else {
+		attempt.StatusCode = statusCode
+		metrics.ProcessorPaymentResponses.WithLabelValues(payment.ProcessingType, strconv.Itoa(statusCode)).Inc()
+
+		if statusCode != http.StatusOK {
+			err = &PaymentError{
+				Class:      p.retryPolicy.classifyStatus(statusCode),
+				Processor:  payment.ProcessingType,
+				StatusCode: statusCode,
+				Err:        ErrPaymentProcessingFailed,
+			}
 		}
+	}
+
+	p.HealthCheckService.Observe(selected.Name(), latency, processorHealthy(err))
 
-		return fmt.Errorf("payment request failed with status code: %d, in processor: %s", statusCode, payment.ProcessingType)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
 	}
 
-	return nil
+	return attempt, nil
 }
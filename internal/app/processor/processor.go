@@ -0,0 +1,249 @@
+package processor
+
+import (
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/valyala/fasthttp"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// Processor is a payment processor the service can route payments to.
+type Processor interface {
+	Name() string
+	URL() string
+	// Fee is the fraction of each payment charged by the processor.
+	Fee() float64
+	// Priority orders processors, lower values are preferred.
+	Priority() int
+	Timeout() time.Duration
+
+	// SendPayment posts the payment and returns the response status code.
+	SendPayment(payment *models.Payment) (int, error)
+	// LookupPayment returns the payment as recorded by the processor, or nil
+	// when the processor does not have it.
+	LookupPayment(correlationID string) (*models.Payment, error)
+	CheckHealth() (*models.HealthCheck, error)
+	// PaymentsSummary returns the totals recorded by the processor for
+	// payments requested within from and to, both optional.
+	PaymentsSummary(from, to *time.Time) (*models.ProcessorSummary, error)
+	// PurgePayments deletes every payment recorded by the processor.
+	PurgePayments() error
+}
+
+// HTTPProcessor talks to a processor implementing the Rinha payment
+// processor HTTP API.
+type HTTPProcessor struct {
+	cfg          config.ProcessorConfig
+	paymentsUrl  string
+	client       *fasthttp.Client
+	healthClient *fasthttp.Client
+}
+
+func NewHTTPProcessor(cfg config.ProcessorConfig) *HTTPProcessor {
+	return &HTTPProcessor{
+		cfg:          cfg,
+		paymentsUrl:  cfg.URL + "/payments",
+		client:       &fasthttp.Client{MaxConnsPerHost: 1000},
+		healthClient: &fasthttp.Client{MaxConnsPerHost: 10},
+	}
+}
+
+// NewProcessors builds the configured processors ordered by priority.
+func NewProcessors(cfgs []config.ProcessorConfig) []Processor {
+	processors := make([]Processor, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		processors = append(processors, NewHTTPProcessor(cfg))
+	}
+
+	sort.SliceStable(processors, func(i, j int) bool {
+		return processors[i].Priority() < processors[j].Priority()
+	})
+
+	return processors
+}
+
+// Names returns the processor names in the given order.
+func Names(processors []Processor) []string {
+	names := make([]string, 0, len(processors))
+	for _, p := range processors {
+		names = append(names, p.Name())
+	}
+
+	return names
+}
+
+func (p *HTTPProcessor) Name() string           { return p.cfg.Name }
+func (p *HTTPProcessor) URL() string            { return p.cfg.URL }
+func (p *HTTPProcessor) Fee() float64           { return p.cfg.Fee }
+func (p *HTTPProcessor) Priority() int          { return p.cfg.Priority }
+func (p *HTTPProcessor) Timeout() time.Duration { return p.cfg.Timeout }
+
+func (p *HTTPProcessor) SendPayment(payment *models.Payment) (int, error) {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	payload, err := sonic.Marshal(payment)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payment: %w", err)
+	}
+
+	req.SetRequestURI(p.paymentsUrl)
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(payload)
+
+	if err := p.client.DoTimeout(req, resp, p.cfg.Timeout); err != nil {
+		return 0, err
+	}
+
+	return resp.StatusCode(), nil
+}
+
+func (p *HTTPProcessor) LookupPayment(correlationID string) (*models.Payment, error) {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	req.SetRequestURI(p.paymentsUrl + "/" + url.PathEscape(correlationID))
+	req.Header.SetMethod(http.MethodGet)
+
+	if err := p.client.DoTimeout(req, resp, p.cfg.Timeout); err != nil {
+		return nil, fmt.Errorf("failed to make payment lookup request: %w", err)
+	}
+
+	statusCode := resp.StatusCode()
+	if statusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("payment lookup request failed with status code: %d", statusCode)
+	}
+
+	var payment models.Payment
+	if err := sonic.Unmarshal(resp.Body(), &payment); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payment lookup response: %w", err)
+	}
+
+	return &payment, nil
+}
+
+func (p *HTTPProcessor) CheckHealth() (*models.HealthCheck, error) {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	req.SetRequestURI(p.cfg.URL + "/payments/service-health")
+	req.Header.SetMethod(http.MethodGet)
+	req.Header.SetContentType("application/json")
+
+	if err := p.healthClient.DoTimeout(req, resp, healthCheckTimeout); err != nil {
+		return nil, fmt.Errorf("failed to make health check request: %w", err)
+	}
+
+	statusCode := resp.StatusCode()
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("health check request failed with status code: %d", statusCode)
+	}
+
+	var healthCheck models.HealthCheck
+	if err := sonic.Unmarshal(resp.Body(), &healthCheck); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal health check response: %w", err)
+	}
+
+	return &healthCheck, nil
+}
+
+func (p *HTTPProcessor) PurgePayments() error {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	req.SetRequestURI(p.cfg.URL + "/admin/purge-payments")
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.Set("X-Rinha-Token", p.cfg.AdminToken)
+
+	if err := p.healthClient.DoTimeout(req, resp, p.cfg.AdminTimeout); err != nil {
+		return fmt.Errorf("failed to make purge request: %w", err)
+	}
+
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return fmt.Errorf("failed to prune payments, status code: %d", statusCode)
+	}
+
+	return nil
+}
+
+// adminSummary is the processor admin summary, whose amounts are floats.
+type adminSummary struct {
+	TotalRequests     int     `json:"totalRequests"`
+	TotalAmount       float64 `json:"totalAmount"`
+	TotalFee          float64 `json:"totalFee"`
+	FeePerTransaction float64 `json:"feePerTransaction"`
+}
+
+func (p *HTTPProcessor) PaymentsSummary(from, to *time.Time) (*models.ProcessorSummary, error) {
+	req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	query := url.Values{}
+	if from != nil {
+		query.Set("from", from.UTC().Format(time.RFC3339Nano))
+	}
+	if to != nil {
+		query.Set("to", to.UTC().Format(time.RFC3339Nano))
+	}
+
+	summaryUrl := p.cfg.URL + "/admin/payments-summary"
+	if len(query) > 0 {
+		summaryUrl += "?" + query.Encode()
+	}
+
+	req.SetRequestURI(summaryUrl)
+	req.Header.SetMethod(http.MethodGet)
+	req.Header.Set("X-Rinha-Token", p.cfg.AdminToken)
+
+	if err := p.healthClient.DoTimeout(req, resp, p.cfg.AdminTimeout); err != nil {
+		return nil, fmt.Errorf("failed to make payments summary request: %w", err)
+	}
+
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return nil, fmt.Errorf("payments summary request failed with status code: %d", statusCode)
+	}
+
+	var summary adminSummary
+	if err := sonic.Unmarshal(resp.Body(), &summary); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payments summary response: %w", err)
+	}
+
+	return &models.ProcessorSummary{
+		TotalRequests:     summary.TotalRequests,
+		TotalAmount:       toMoney(summary.TotalAmount),
+		TotalFee:          toMoney(summary.TotalFee),
+		FeePerTransaction: summary.FeePerTransaction,
+	}, nil
+}
+
+func toMoney(amount float64) models.Money {
+	return models.Money(math.Round(amount * 100))
+}
@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	drainWindow = 1 * time.Second
+	drainAlpha  = 0.3
+)
+
+// DrainMeter estimates how many messages per second are acknowledged, as a
+// moving average over one second windows.
+type DrainMeter struct {
+	mu          sync.Mutex
+	count       int
+	windowStart time.Time
+	rate        float64
+}
+
+func NewDrainMeter() *DrainMeter {
+	return &DrainMeter{
+		windowStart: time.Now(),
+	}
+}
+
+func (m *DrainMeter) Mark() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(time.Now())
+	m.count++
+}
+
+// Rate returns the acknowledged messages per second.
+func (m *DrainMeter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(time.Now())
+	return m.rate
+}
+
+func (m *DrainMeter) roll(now time.Time) {
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < drainWindow {
+		return
+	}
+
+	current := float64(m.count) / elapsed.Seconds()
+	if m.rate == 0 {
+		m.rate = current
+	} else {
+		m.rate += drainAlpha * (current - m.rate)
+	}
+
+	m.count = 0
+	m.windowStart = now
+}
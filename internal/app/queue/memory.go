@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"sync"
+	"time"
+)
+
+// MemoryQueue keeps payments in a buffered channel. Everything queued is lost
+// on restart, so it is only meant for benchmarks. When the buffer is full
+// Enqueue waits up to wait for a worker to make room.
+type MemoryQueue struct {
+	mu       sync.RWMutex
+	closed   bool
+	messages chan *Message
+	wait     time.Duration
+	drain    *DrainMeter
+}
+
+func NewMemoryQueue(bufferSize int, wait time.Duration) *MemoryQueue {
+	return &MemoryQueue{
+		messages: make(chan *Message, bufferSize),
+		wait:     wait,
+		drain:    NewDrainMeter(),
+	}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, payment *models.Payment) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	message := &Message{ID: payment.CorrelationID, Payment: payment}
+
+	select {
+	case q.messages <- message:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(q.wait)
+	defer timer.Stop()
+
+	select {
+	case q.messages <- message:
+		return nil
+	case <-timer.C:
+		return ErrQueueFull
+	case <-ctx.Done():
+		return ErrQueueFull
+	}
+}
+
+// EnqueueBatch holds the write lock so no other payment takes the free room
+// between checking it and filling it.
+func (q *MemoryQueue) EnqueueBatch(ctx context.Context, payments []*models.Payment) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	if cap(q.messages)-len(q.messages) < len(payments) {
+		return ErrQueueFull
+	}
+
+	for _, payment := range payments {
+		q.messages <- &Message{ID: payment.CorrelationID, Payment: payment}
+	}
+
+	return nil
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context) (*Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case message, ok := <-q.messages:
+		if !ok {
+			return nil, ErrQueueClosed
+		}
+
+		return message, nil
+	}
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, message *Message) error {
+	q.drain.Mark()
+	return nil
+}
+
+func (q *MemoryQueue) Len(ctx context.Context) (int64, error) {
+	return int64(len(q.messages)), nil
+}
+
+// Purge drops the buffered payments. The write lock keeps Close from closing
+// the buffer while it is drained.
+func (q *MemoryQueue) Purge(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	for {
+		select {
+		case <-q.messages:
+		default:
+			return nil
+		}
+	}
+}
+
+func (q *MemoryQueue) DrainRate() float64 {
+	return q.drain.Rate()
+}
+
+func (q *MemoryQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+
+	return nil
+}
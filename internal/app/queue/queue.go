@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+)
+
+var (
+	ErrQueueFull   = fmt.Errorf("payment queue is full")
+	ErrQueueClosed = fmt.Errorf("payment queue is closed")
+)
+
+// Message is a payment delivered by a Queue. It must be acknowledged once the
+// payment is persisted, otherwise durable implementations will redeliver it.
+type Message struct {
+	ID      string
+	Payment *models.Payment
+}
+
+type Queue interface {
+	// Enqueue returns ErrQueueFull when the queue has no room for the
+	// payment.
+	Enqueue(ctx context.Context, payment *models.Payment) error
+	// EnqueueBatch enqueues either all the payments or none of them.
+	EnqueueBatch(ctx context.Context, payments []*models.Payment) error
+	Dequeue(ctx context.Context) (*Message, error)
+	Ack(ctx context.Context, message *Message) error
+	Len(ctx context.Context) (int64, error)
+	// Purge deletes every queued payment, including the ones delivered to
+	// workers but not acknowledged yet.
+	Purge(ctx context.Context) error
+	// DrainRate is the number of messages acknowledged per second by this
+	// instance.
+	DrainRate() float64
+	Close() error
+}
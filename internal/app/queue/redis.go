@@ -0,0 +1,518 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	paymentsStreamKey = "payments_stream"
+	paymentsGroup     = "payment_workers"
+	paymentField      = "payment"
+	drainRatesKey     = "payments_drain_rates"
+	readBatchSize     = 100
+	readBlock         = 1 * time.Second
+	claimInterval     = 5 * time.Second
+	errorBackoff      = 1 * time.Second
+	// enqueueRetryInterval is how often a full stream is checked for room
+	// while Enqueue waits.
+	enqueueRetryInterval = 5 * time.Millisecond
+	// Every consumer publishes its drain rate each drainInterval. Rates older
+	// than drainMaxAge are left out and removed after drainExpireAfter.
+	drainInterval    = 1 * time.Second
+	drainMaxAge      = 5 * time.Second
+	drainExpireAfter = 1 * time.Minute
+)
+
+// enqueueScript appends the payments to the stream only if they all fit
+// under its maximum length.
+//
+// KEYS: stream
+// ARGV: max length, field, payloads...
+var enqueueScript = redis.NewScript(`
+if redis.call('XLEN', KEYS[1]) + #ARGV - 2 > tonumber(ARGV[1]) then
+	return 0
+end
+
+for i = 3, #ARGV do
+	redis.call('XADD', KEYS[1], '*', ARGV[2], ARGV[i])
+end
+
+return 1
+`)
+
+// RedisQueue is a durable queue backed by a Redis stream consumer group.
+// Messages stay pending until acknowledged and entries left pending by other
+// consumers, e.g. dead ones, are claimed after claimMinIdle. The stream,
+// shared by every instance, holds at most maxLen payments; when it is full
+// Enqueue waits up to wait for room.
+type RedisQueue struct {
+	cache        *redis.Client
+	consumer     string
+	claimMinIdle time.Duration
+	maxLen       int
+	wait         time.Duration
+
+	messages  chan *Message
+	drain     *DrainMeter
+	drainRate atomic.Uint64
+	done      chan struct{}
+	wg        sync.WaitGroup
+	once      sync.Once
+}
+
+func NewRedisQueue(ctx context.Context, cache *redis.Client, consumer string, bufferSize int, wait, claimMinIdle time.Duration) (*RedisQueue, error) {
+	err := cache.XGroupCreateMkStream(ctx, paymentsStreamKey, paymentsGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	q := &RedisQueue{
+		cache:        cache,
+		consumer:     consumer,
+		claimMinIdle: claimMinIdle,
+		maxLen:       bufferSize,
+		wait:         wait,
+		messages:     make(chan *Message, bufferSize),
+		drain:        NewDrainMeter(),
+		done:         make(chan struct{}),
+	}
+
+	q.wg.Add(3)
+	go q.readRoutine(ctx)
+	go q.claimRoutine(ctx)
+	go q.drainRoutine(ctx)
+
+	go func() {
+		q.wg.Wait()
+		close(q.messages)
+	}()
+
+	return q, nil
+}
+
+func (q *RedisQueue) Enqueue(ctx context.Context, payment *models.Payment) error {
+	select {
+	case <-q.done:
+		return ErrQueueClosed
+	default:
+	}
+
+	payload, err := sonic.ConfigFastest.Marshal(payment)
+	if err != nil {
+		return err
+	}
+
+	added, err := q.add(ctx, payload)
+	if err != nil || added {
+		return err
+	}
+
+	timer := time.NewTimer(q.wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(enqueueRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return ErrQueueFull
+		case <-ctx.Done():
+			return ErrQueueFull
+		}
+
+		added, err := q.add(ctx, payload)
+		if err != nil || added {
+			return err
+		}
+	}
+}
+
+// EnqueueBatch does not wait for room, like MemoryQueue.
+func (q *RedisQueue) EnqueueBatch(ctx context.Context, payments []*models.Payment) error {
+	select {
+	case <-q.done:
+		return ErrQueueClosed
+	default:
+	}
+
+	payloads := make([][]byte, len(payments))
+	for i, payment := range payments {
+		payload, err := sonic.ConfigFastest.Marshal(payment)
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+
+	added, err := q.add(ctx, payloads...)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+// add appends the payloads to the stream, reporting false when they do not
+// fit.
+func (q *RedisQueue) add(ctx context.Context, payloads ...[]byte) (bool, error) {
+	args := make([]any, 0, len(payloads)+2)
+	args = append(args, q.maxLen, paymentField)
+	for _, payload := range payloads {
+		args = append(args, payload)
+	}
+
+	added, err := enqueueScript.Run(ctx, q.cache, []string{paymentsStreamKey}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return added == 1, nil
+}
+
+func (q *RedisQueue) Dequeue(ctx context.Context) (*Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case message, ok := <-q.messages:
+		if !ok {
+			return nil, ErrQueueClosed
+		}
+
+		return message, nil
+	}
+}
+
+func (q *RedisQueue) Ack(ctx context.Context, message *Message) error {
+	_, err := q.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, paymentsStreamKey, paymentsGroup, message.ID)
+		pipe.XDel(ctx, paymentsStreamKey, message.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	q.drain.Mark()
+	return nil
+}
+
+// Len reports the entries in the stream, i.e. payments not yet acknowledged by
+// any instance.
+func (q *RedisQueue) Len(ctx context.Context) (int64, error) {
+	return q.cache.XLen(ctx, paymentsStreamKey).Result()
+}
+
+// Purge deletes the stream, its pending entries with it, and creates the
+// consumer group again. Payments this consumer buffered are dropped; the ones
+// other instances buffered are still processed.
+func (q *RedisQueue) Purge(ctx context.Context) error {
+	_, err := q.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, paymentsStreamKey)
+		pipe.XGroupCreateMkStream(ctx, paymentsStreamKey, paymentsGroup, "0")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-q.messages:
+		default:
+			return nil
+		}
+	}
+}
+
+// DrainRate is the rate at which every consumer together acknowledges
+// messages, as the stream length it is compared with is shared too. Until the
+// rates of the other consumers are known it is this consumer's rate.
+func (q *RedisQueue) DrainRate() float64 {
+	if rate := math.Float64frombits(q.drainRate.Load()); rate > 0 {
+		return rate
+	}
+
+	return q.drain.Rate()
+}
+
+func (q *RedisQueue) Close() error {
+	q.once.Do(func() {
+		close(q.done)
+	})
+
+	return nil
+}
+
+func (q *RedisQueue) readRoutine(ctx context.Context) {
+	defer q.wg.Done()
+
+	// Entries already pending for this consumer (e.g. from before a restart)
+	// are delivered first, then new entries.
+	lastID := "0"
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.done:
+			return
+		default:
+		}
+
+		streams, err := q.cache.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    paymentsGroup,
+			Consumer: q.consumer,
+			Streams:  []string{paymentsStreamKey, lastID},
+			Count:    readBatchSize,
+			Block:    readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("Error reading payments stream for consumer %s: %v\n", q.consumer, err)
+			time.Sleep(errorBackoff)
+			continue
+		}
+
+		delivered := 0
+		for _, stream := range streams {
+			delivered += len(stream.Messages)
+
+			for _, entry := range stream.Messages {
+				if lastID != ">" {
+					lastID = entry.ID
+				}
+
+				if !q.deliver(ctx, entry) {
+					return
+				}
+			}
+		}
+
+		if lastID != ">" && delivered == 0 {
+			lastID = ">"
+		}
+	}
+}
+
+// claimRoutine takes over the entries other consumers left pending for longer
+// than claimMinIdle, e.g. because their instance died. Entries pending for
+// this consumer are never claimed back: they are either buffered or being
+// processed by its workers, and are only read again after a restart.
+func (q *RedisQueue) claimRoutine(ctx context.Context) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(claimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.done:
+			return
+		case <-ticker.C:
+		}
+
+		start := "-"
+		for {
+			pending, err := q.cache.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: paymentsStreamKey,
+				Group:  paymentsGroup,
+				Idle:   q.claimMinIdle,
+				Start:  start,
+				End:    "+",
+				Count:  readBatchSize,
+			}).Result()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error listing pending payments for consumer %s: %v\n", q.consumer, err)
+				}
+				break
+			}
+
+			var ids []string
+			for _, entry := range pending {
+				if entry.Consumer != q.consumer {
+					ids = append(ids, entry.ID)
+				}
+			}
+
+			if len(ids) > 0 {
+				// XCLAIM checks the idle time again, so an entry another
+				// consumer claimed in the meantime is skipped
+				entries, err := q.cache.XClaim(ctx, &redis.XClaimArgs{
+					Stream:   paymentsStreamKey,
+					Group:    paymentsGroup,
+					Consumer: q.consumer,
+					MinIdle:  q.claimMinIdle,
+					Messages: ids,
+				}).Result()
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Error claiming pending payments for consumer %s: %v\n", q.consumer, err)
+					}
+					break
+				}
+
+				for _, entry := range entries {
+					if !q.deliver(ctx, entry) {
+						return
+					}
+				}
+			}
+
+			if len(pending) < readBatchSize {
+				break
+			}
+			start = nextStreamID(pending[len(pending)-1].ID)
+		}
+	}
+}
+
+// drainRoutine publishes this consumer's drain rate and sums the rates every
+// consumer published.
+func (q *RedisQueue) drainRoutine(ctx context.Context) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.done:
+			return
+		case <-ticker.C:
+		}
+
+		rate, err := q.syncDrainRate(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error syncing payments drain rate for consumer %s: %v\n", q.consumer, err)
+			}
+			continue
+		}
+
+		q.drainRate.Store(math.Float64bits(rate))
+	}
+}
+
+// syncDrainRate publishes this consumer's rate, as "<rate>|<unix ms>", and
+// returns the sum of the fresh rates, removing the ones of consumers that
+// stopped publishing.
+func (q *RedisQueue) syncDrainRate(ctx context.Context) (float64, error) {
+	now := time.Now()
+	value := strconv.FormatFloat(q.drain.Rate(), 'f', -1, 64) + "|" + strconv.FormatInt(now.UnixMilli(), 10)
+
+	var rates *redis.MapStringStringCmd
+	_, err := q.cache.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, drainRatesKey, q.consumer, value)
+		rates = pipe.HGetAll(ctx, drainRatesKey)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	var stale []string
+
+	for consumer, value := range rates.Val() {
+		rateValue, msValue, _ := strings.Cut(value, "|")
+		rate, rateErr := strconv.ParseFloat(rateValue, 64)
+		ms, msErr := strconv.ParseInt(msValue, 10, 64)
+		if rateErr != nil || msErr != nil {
+			stale = append(stale, consumer)
+			continue
+		}
+
+		age := now.Sub(time.UnixMilli(ms))
+		if age > drainExpireAfter {
+			stale = append(stale, consumer)
+		}
+		if age > drainMaxAge {
+			continue
+		}
+
+		total += rate
+	}
+
+	if len(stale) > 0 {
+		if err := q.cache.HDel(ctx, drainRatesKey, stale...).Err(); err != nil {
+			log.Printf("Error removing stale payments drain rates: %v\n", err)
+		}
+	}
+
+	return total, nil
+}
+
+func (q *RedisQueue) deliver(ctx context.Context, entry redis.XMessage) bool {
+	message, err := decodeMessage(entry)
+	if err != nil {
+		log.Printf("Error decoding payment stream entry %s, discarding: %v\n", entry.ID, err)
+		q.Ack(ctx, &Message{ID: entry.ID})
+		return true
+	}
+
+	select {
+	case q.messages <- message:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-q.done:
+		return false
+	}
+}
+
+func decodeMessage(entry redis.XMessage) (*Message, error) {
+	raw, ok := entry.Values[paymentField].(string)
+	if !ok {
+		return nil, errors.New("missing payment field")
+	}
+
+	var payment models.Payment
+	if err := sonic.ConfigFastest.UnmarshalFromString(raw, &payment); err != nil {
+		return nil, err
+	}
+
+	return &Message{ID: entry.ID, Payment: &payment}, nil
+}
+
+// nextStreamID returns the ID right after id, to page through stream ranges
+// without exclusive bounds.
+func nextStreamID(id string) string {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return id
+	}
+
+	next, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return id
+	}
+
+	return ms + "-" + strconv.FormatUint(next+1, 10)
+}
@@ -0,0 +1,201 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	reportKey = "reconciliation_report"
+	// lockKey makes a single instance run the job each interval.
+	lockKey = "reconciliation_lock"
+)
+
+var ErrNoReport = fmt.Errorf("no reconciliation report")
+
+// ReconciliationService compares the payments summary kept in storage with
+// the summaries recorded by the processors, to detect payments charged but
+// never stored (or stored but never charged).
+type ReconciliationService struct {
+	processors     []processor.Processor
+	storageService *storage.StorageService
+	cache          *redis.Client
+	interval       time.Duration
+	window         time.Duration
+	lag            time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReconciliationService(processors []processor.Processor, storageService *storage.StorageService, cache *redis.Client, cfg config.Reconciliation) *ReconciliationService {
+	return &ReconciliationService{
+		processors:     processors,
+		storageService: storageService,
+		cache:          cache,
+		interval:       cfg.ReconcileInterval,
+		window:         cfg.ReconcileWindow,
+		lag:            cfg.ReconcileLag,
+	}
+}
+
+// Reconcile compares the summaries of payments requested within from and to,
+// both optional. A processor whose summary cannot be fetched is reported with
+// its error instead of failing the whole report.
+func (s *ReconciliationService) Reconcile(ctx context.Context, from, to *time.Time) (*models.ReconciliationReport, error) {
+	stored, err := s.storageService.GetPaymentsSummary(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ReconciliationReport{
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now().UTC(),
+		Processors:  make([]models.ProcessorReconciliation, 0, len(s.processors)),
+	}
+
+	for _, p := range s.processors {
+		result := models.ProcessorReconciliation{
+			Processor: p.Name(),
+			Stored:    (*stored)[p.Name()],
+		}
+
+		processed, err := p.PaymentsSummary(from, to)
+		if err != nil {
+			result.Error = err.Error()
+			result.HasDiscrepancies = true
+		} else {
+			result.Processed = processed
+			result.RequestsDiff = processed.TotalRequests - result.Stored.TotalRequests
+			result.AmountDiff = processed.TotalAmount - result.Stored.TotalAmount
+			result.HasDiscrepancies = result.RequestsDiff != 0 || result.AmountDiff != 0
+
+			metrics.ReconciliationDiscrepancy.WithLabelValues(p.Name(), "requests").Set(float64(result.RequestsDiff))
+			metrics.ReconciliationDiscrepancy.WithLabelValues(p.Name(), "amount").Set(float64(result.AmountDiff.Cents()))
+		}
+
+		report.HasDiscrepancies = report.HasDiscrepancies || result.HasDiscrepancies
+		report.Processors = append(report.Processors, result)
+	}
+
+	return report, nil
+}
+
+// Window returns the window reconciled by the background job at now.
+func (s *ReconciliationService) Window(now time.Time) (time.Time, time.Time) {
+	to := now.Add(-s.lag).UTC()
+	return to.Add(-s.window), to
+}
+
+// Latest returns the last report saved by the background job.
+func (s *ReconciliationService) Latest(ctx context.Context) (*models.ReconciliationReport, error) {
+	data, err := s.cache.Get(ctx, reportKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNoReport
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report models.ReconciliationReport
+	if err := sonic.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// Save stores report as the latest one, shared by every instance.
+func (s *ReconciliationService) Save(ctx context.Context, report *models.ReconciliationReport) error {
+	data, err := sonic.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Set(ctx, reportKey, data, 0).Err()
+}
+
+// Start runs the reconciliation every interval in the background, on a single
+// instance at a time, until Shutdown.
+func (s *ReconciliationService) Start(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.runOnce(ctx)
+			}
+		}
+	}()
+}
+
+func (s *ReconciliationService) Shutdown() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+func (s *ReconciliationService) runOnce(ctx context.Context) {
+	now := time.Now()
+
+	// The lock expires shortly before the next tick so the job keeps running
+	// when the instance holding it dies.
+	acquired, err := s.cache.SetNX(ctx, lockKey, strconv.FormatInt(now.UnixMilli(), 10), s.interval*9/10).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error acquiring reconciliation lock: %v\n", err)
+		}
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	from, to := s.Window(now)
+	report, err := s.Reconcile(ctx, &from, &to)
+	if err != nil {
+		log.Printf("Error reconciling payments: %v\n", err)
+		return
+	}
+
+	if report.HasDiscrepancies {
+		for _, result := range report.Processors {
+			if result.HasDiscrepancies {
+				log.Printf("Reconciliation found discrepancies on %s: requests %+d, amount %s, error %q\n",
+					result.Processor, result.RequestsDiff, result.AmountDiff, result.Error)
+			}
+		}
+	}
+
+	if err := s.Save(ctx, report); err != nil {
+		log.Printf("Error saving reconciliation report: %v\n", err)
+	}
+}
@@ -0,0 +1,74 @@
+package retry
+
+import (
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delay before the next attempt of a payment that
+// already failed attempts times, the last retry having waited previous.
+type Backoff interface {
+	Next(attempts int, previous time.Duration) time.Duration
+}
+
+func NewBackoff(cfg config.Retry) (Backoff, error) {
+	switch cfg.RetryBackoff {
+	case "exponential":
+		return &ExponentialBackoff{Base: cfg.RetryBaseDelay, Max: cfg.RetryMaxDelay}, nil
+	case "decorrelated":
+		return &DecorrelatedBackoff{Base: cfg.RetryBaseDelay, Max: cfg.RetryMaxDelay}, nil
+	case "fixed":
+		return &FixedBackoff{Delay: cfg.RetryBaseDelay}, nil
+	}
+
+	return nil, fmt.Errorf("unknown retry backoff: %s", cfg.RetryBackoff)
+}
+
+// ExponentialBackoff doubles the delay on every attempt, adding up to Base of
+// jitter so retries of payments that failed together spread out. The delay,
+// jitter included, never exceeds Max.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b *ExponentialBackoff) Next(attempts int, previous time.Duration) time.Duration {
+	delay := b.Base << min(max(attempts-1, 0), 30)
+	if delay <= 0 || delay > b.Max {
+		delay = b.Max
+	}
+
+	return min(delay+jitter(b.Base), b.Max)
+}
+
+// DecorrelatedBackoff picks a random delay between Base and three times the
+// previous one, as in the "decorrelated jitter" of the AWS architecture blog.
+type DecorrelatedBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b *DecorrelatedBackoff) Next(attempts int, previous time.Duration) time.Duration {
+	upper := max(previous*3, b.Base)
+
+	delay := b.Base + jitter(upper-b.Base)
+	return min(delay, b.Max)
+}
+
+type FixedBackoff struct {
+	Delay time.Duration
+}
+
+func (b *FixedBackoff) Next(attempts int, previous time.Duration) time.Duration {
+	return b.Delay
+}
+
+func jitter(upTo time.Duration) time.Duration {
+	if upTo <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(upTo)))
+}
@@ -0,0 +1,58 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffNextStaysUnderMax(t *testing.T) {
+	const (
+		base     = 100 * time.Millisecond
+		maxDelay = time.Second
+	)
+
+	tests := []struct {
+		name    string
+		backoff Backoff
+	}{
+		{name: "exponential", backoff: &ExponentialBackoff{Base: base, Max: maxDelay}},
+		{name: "decorrelated", backoff: &DecorrelatedBackoff{Base: base, Max: maxDelay}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for attempts := 1; attempts <= 40; attempts++ {
+				previous := time.Duration(0)
+
+				for range 100 {
+					delay := tt.backoff.Next(attempts, previous)
+					if delay < 0 || delay > maxDelay {
+						t.Fatalf("Next(%d, %v) = %v, want within [0, %v]", attempts, previous, delay, maxDelay)
+					}
+					previous = delay
+				}
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffNextDoubles(t *testing.T) {
+	backoff := &ExponentialBackoff{Base: 100 * time.Millisecond, Max: time.Minute}
+
+	tests := []struct {
+		attempts int
+		min      time.Duration
+	}{
+		{attempts: 1, min: 100 * time.Millisecond},
+		{attempts: 2, min: 200 * time.Millisecond},
+		{attempts: 3, min: 400 * time.Millisecond},
+		{attempts: 4, min: 800 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		delay := backoff.Next(tt.attempts, 0)
+		if delay < tt.min || delay >= tt.min+backoff.Base {
+			t.Errorf("Next(%d) = %v, want within [%v, %v)", tt.attempts, delay, tt.min, tt.min+backoff.Base)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package retry
+
+import (
+	"context"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	retriesKey      = "payment_retries"
+	retryEntriesKey = "payment_retry_entries"
+)
+
+// claimScript returns the entries due by now and pushes their due time
+// forward by the lease, so no other instance claims them while they are
+// attempted. An entry whose lease expires, e.g. because its instance died, is
+// claimed again by any instance.
+//
+// KEYS: retries zset, entries hash
+// ARGV: now ms, count, lease ms
+var claimScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, tonumber(ARGV[2]))
+
+local entries = {}
+for _, id in ipairs(ids) do
+	local entry = redis.call('HGET', KEYS[2], id)
+	if entry then
+		redis.call('ZADD', KEYS[1], now + tonumber(ARGV[3]), id)
+		table.insert(entries, entry)
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+
+return entries
+`)
+
+// renewScript extends the lease of a claimed entry and saves it, unless the
+// lease was lost: the entry was completed, scheduled again, or claimed by
+// another instance after the lease expired.
+//
+// KEYS: retries zset, entries hash
+// ARGV: correlation id, current lease ms, new lease ms, entry
+var renewScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[4])
+return 1
+`)
+
+// RetryScheduler keeps the payments waiting for another attempt in a Redis
+// sorted set scored by their due time, so retries survive restarts and are
+// shared by every instance.
+type RetryScheduler struct {
+	cache       *redis.Client
+	backoff     Backoff
+	maxAttempts int
+	maxAge      time.Duration
+	lease       time.Duration
+}
+
+func NewRetryScheduler(cache *redis.Client, backoff Backoff, cfg config.Retry) *RetryScheduler {
+	return &RetryScheduler{
+		cache:       cache,
+		backoff:     backoff,
+		maxAttempts: cfg.RetryMaxAttempts,
+		maxAge:      cfg.RetryMaxAge,
+		lease:       cfg.RetryLease,
+	}
+}
+
+// Start schedules the first retry of a payment whose first attempt failed.
+// It reports false, scheduling nothing, when the payment may not be retried.
+func (s *RetryScheduler) Start(ctx context.Context, event *models.Payment, reason error) (bool, error) {
+	entry := &models.RetryEntry{
+		Payment:       event,
+		FirstFailedAt: time.Now().UTC(),
+	}
+
+	return s.Retry(ctx, entry, reason)
+}
+
+// StartLookup schedules a payment the processors may have charged, e.g. one
+// charged but that could not be saved. It is marked as possibly charged by
+// them, so the retry looks it up and saves it instead of sending it again.
+func (s *RetryScheduler) StartLookup(ctx context.Context, event *models.Payment, reason error, processors []string) error {
+	entry := &models.RetryEntry{
+		Payment:             event,
+		FirstFailedAt:       time.Now().UTC(),
+		LastError:           reason.Error(),
+		AmbiguousProcessors: processors,
+	}
+
+	return s.Delay(ctx, entry, 0)
+}
+
+// Retry counts a failed attempt of the entry and schedules the next one with
+// the backoff. It reports false, scheduling nothing, once the entry reached
+// the maximum attempts or age.
+func (s *RetryScheduler) Retry(ctx context.Context, entry *models.RetryEntry, reason error) (bool, error) {
+	entry.Attempts++
+	if reason != nil {
+		entry.LastError = reason.Error()
+	}
+
+	if processor, ok := payment.AmbiguousProcessor(reason); ok && !slices.Contains(entry.AmbiguousProcessors, processor) {
+		entry.AmbiguousProcessors = append(entry.AmbiguousProcessors, processor)
+	}
+
+	if entry.Attempts >= s.maxAttempts || s.Expired(entry) {
+		return false, nil
+	}
+
+	delay := s.backoff.Next(entry.Attempts, time.Duration(entry.LastDelayMs)*time.Millisecond)
+	entry.LastDelayMs = delay.Milliseconds()
+
+	return true, s.Delay(ctx, entry, delay)
+}
+
+// Expired reports whether the entry is past the maximum age and must be given
+// up instead of scheduled again.
+func (s *RetryScheduler) Expired(entry *models.RetryEntry) bool {
+	return time.Since(entry.FirstFailedAt) >= s.maxAge
+}
+
+// Delay schedules the entry again after delay without counting an attempt.
+func (s *RetryScheduler) Delay(ctx context.Context, entry *models.RetryEntry, delay time.Duration) error {
+	entry.NextAttemptAt = time.Now().Add(delay).UTC()
+	entry.Attempting = false
+
+	payload, err := sonic.ConfigFastest.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	id := entry.Payment.CorrelationID
+	_, err = s.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, retryEntriesKey, id, payload)
+		pipe.ZAdd(ctx, retriesKey, redis.Z{
+			Score:  float64(entry.NextAttemptAt.UnixMilli()),
+			Member: id,
+		})
+		return nil
+	})
+
+	return err
+}
+
+// Claim leases up to count due entries to the caller, which must Renew each
+// of them before attempting it, then Complete, Retry or Delay it.
+func (s *RetryScheduler) Claim(ctx context.Context, count int) ([]*models.RetryEntry, error) {
+	now := time.Now().UnixMilli()
+	values, err := claimScript.Run(ctx, s.cache, []string{retriesKey, retryEntriesKey},
+		now, count, s.lease.Milliseconds()).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.RetryEntry, 0, len(values))
+	for _, value := range values {
+		var entry models.RetryEntry
+		if err := sonic.ConfigFastest.UnmarshalFromString(value, &entry); err != nil {
+			log.Printf("Skipping undecodable retry entry: %v\n", err)
+			continue
+		}
+		entry.LeasedUntilMs = now + s.lease.Milliseconds()
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// Renew extends the lease of a claimed entry by a whole lease from now, so an
+// entry claimed in a batch is never attempted after its lease expired, and
+// marks it as attempting. It reports false when the lease was lost and the
+// entry must be left alone.
+func (s *RetryScheduler) Renew(ctx context.Context, entry *models.RetryEntry) (bool, error) {
+	leasedUntil := time.Now().Add(s.lease).UnixMilli()
+
+	attempting := *entry
+	attempting.Attempting = true
+
+	payload, err := sonic.ConfigFastest.Marshal(&attempting)
+	if err != nil {
+		return false, err
+	}
+
+	renewed, err := renewScript.Run(ctx, s.cache, []string{retriesKey, retryEntriesKey},
+		entry.Payment.CorrelationID, entry.LeasedUntilMs, leasedUntil, payload).Int()
+	if err != nil || renewed == 0 {
+		return false, err
+	}
+
+	entry.LeasedUntilMs = leasedUntil
+	return true, nil
+}
+
+// Complete removes the entry, once its payment succeeded or was given up.
+func (s *RetryScheduler) Complete(ctx context.Context, correlationID string) error {
+	_, err := s.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, retriesKey, correlationID)
+		pipe.HDel(ctx, retryEntriesKey, correlationID)
+		return nil
+	})
+
+	return err
+}
+
+func (s *RetryScheduler) Len(ctx context.Context) (int64, error) {
+	return s.cache.ZCard(ctx, retriesKey).Result()
+}
+
+// List returns the scheduled retries ordered by due time.
+func (s *RetryScheduler) List(ctx context.Context, offset, limit int64) (*models.RetryList, error) {
+	total, err := s.cache.ZCard(ctx, retriesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &models.RetryList{
+		Total: total,
+		Items: []models.RetryEntry{},
+	}
+
+	ids, err := s.cache.ZRange(ctx, retriesKey, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return list, nil
+	}
+
+	values, err := s.cache.HMGet(ctx, retryEntriesKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var entry models.RetryEntry
+		if err := sonic.ConfigFastest.UnmarshalFromString(data, &entry); err != nil {
+			return nil, err
+		}
+
+		list.Items = append(list.Items, entry)
+	}
+
+	return list, nil
+}
+
+func (s *RetryScheduler) Purge(ctx context.Context) error {
+	return s.cache.Del(ctx, retriesKey, retryEntriesKey).Err()
+}
@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	observationsAlpha = 0.2
+	latencyWindowSize = 256
+)
+
+// Observations keeps, for each processor, an exponentially weighted moving
+// average of the success rate and latency of the payments sent to it, plus a
+// window of the latest latencies for percentiles.
+type Observations struct {
+	mu    sync.Mutex
+	stats map[string]*observedStats
+}
+
+type observedStats struct {
+	successRate float64
+	latencyMs   float64
+	latencies   [latencyWindowSize]float64
+	next        int
+	samples     int
+	updatedAt   time.Time
+}
+
+func NewObservations() *Observations {
+	return &Observations{
+		stats: make(map[string]*observedStats),
+	}
+}
+
+func (o *Observations) Observe(processor string, latency time.Duration, success bool) {
+	sample := 0.0
+	if success {
+		sample = 1
+	}
+	latencyMs := float64(latency.Microseconds()) / 1000
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats, ok := o.stats[processor]
+	if !ok {
+		stats = &observedStats{successRate: sample, latencyMs: latencyMs}
+		o.stats[processor] = stats
+	} else {
+		stats.successRate += observationsAlpha * (sample - stats.successRate)
+		stats.latencyMs += observationsAlpha * (latencyMs - stats.latencyMs)
+	}
+
+	stats.latencies[stats.next] = latencyMs
+	stats.next = (stats.next + 1) % latencyWindowSize
+	if stats.samples < latencyWindowSize {
+		stats.samples++
+	}
+	stats.updatedAt = time.Now().UTC()
+}
+
+// Snapshot returns the current statistics of every observed processor.
+func (o *Observations) Snapshot() map[string]models.PassiveHealth {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	snapshot := make(map[string]models.PassiveHealth, len(o.stats))
+	for processor, stats := range o.stats {
+		latencies := make([]float64, stats.samples)
+		copy(latencies, stats.latencies[:stats.samples])
+		sort.Float64s(latencies)
+
+		snapshot[processor] = models.PassiveHealth{
+			SuccessRate:  stats.successRate,
+			LatencyMs:    stats.latencyMs,
+			P99LatencyMs: latencies[(len(latencies)*99)/100],
+			Samples:      stats.samples,
+			UpdatedAt:    stats.updatedAt,
+		}
+	}
+
+	return snapshot
+}
+
+// MergePassiveHealth combines the statistics published by several instances
+// for the same processor, weighting each by its number of samples.
+func MergePassiveHealth(entries []models.PassiveHealth) (models.PassiveHealth, bool) {
+	var merged models.PassiveHealth
+
+	for _, entry := range entries {
+		if entry.Samples == 0 {
+			continue
+		}
+
+		weight := float64(entry.Samples)
+		merged.SuccessRate += entry.SuccessRate * weight
+		merged.LatencyMs += entry.LatencyMs * weight
+		merged.Samples += entry.Samples
+
+		if entry.P99LatencyMs > merged.P99LatencyMs {
+			merged.P99LatencyMs = entry.P99LatencyMs
+		}
+		if entry.UpdatedAt.After(merged.UpdatedAt) {
+			merged.UpdatedAt = entry.UpdatedAt
+		}
+	}
+
+	if merged.Samples == 0 {
+		return merged, false
+	}
+
+	merged.SuccessRate /= float64(merged.Samples)
+	merged.LatencyMs /= float64(merged.Samples)
+
+	return merged, true
+}
+
+// Apply fills the observed fields of the candidate from its passive health,
+// leaving the defaults of a processor without observations.
+func Apply(candidate *Candidate, passive map[string]models.PassiveHealth) {
+	candidate.SuccessRate = 1
+	candidate.LatencyMs = 0
+	candidate.P99LatencyMs = 0
+
+	if stats, ok := passive[candidate.Name]; ok {
+		candidate.SuccessRate = stats.SuccessRate
+		candidate.LatencyMs = stats.LatencyMs
+		candidate.P99LatencyMs = stats.P99LatencyMs
+	}
+}
@@ -0,0 +1,169 @@
+package routing
+
+import (
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"sort"
+	"time"
+)
+
+// Candidate is everything a Policy knows about a processor when routing.
+type Candidate struct {
+	Name     string
+	Fee      float64
+	Priority int
+	// Health is the last active health check, nil when unknown.
+	Health *models.HealthCheck
+	// SuccessRate and the latencies are observed from real payments by every
+	// instance. SuccessRate is 1 and the latencies 0 while there are no
+	// observations.
+	SuccessRate  float64
+	LatencyMs    float64
+	P99LatencyMs float64
+}
+
+func (c Candidate) healthy() bool {
+	return c.Health != nil && !c.Health.IsFailing
+}
+
+type Policy interface {
+	// Rank orders the candidate names from best to worst, leaving out the
+	// candidates that should not receive payments at all.
+	Rank(candidates []Candidate) []string
+}
+
+func NewPolicy(cfg config.Routing) (Policy, error) {
+	switch cfg.RoutingPolicy {
+	case "threshold":
+		return &ThresholdPolicy{
+			PrimaryMaxResponseTime:   cfg.RoutingPrimaryMaxResponseTime,
+			SecondaryMaxResponseTime: cfg.RoutingSecondaryMaxResponseTime,
+			MinSuccessRate:           cfg.RoutingMinSuccessRate,
+		}, nil
+	case "profit":
+		return &ProfitPolicy{
+			LatencyBudget: cfg.RoutingLatencyBudget,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown routing policy: %s", cfg.RoutingPolicy)
+}
+
+// ThresholdPolicy prefers processors by priority as long as their latency is
+// under a fixed threshold, the primary processor being allowed a higher one,
+// and their success rate on real payments is at least MinSuccessRate. The
+// latency is the reported minResponseTime or the one observed on real
+// payments, whichever is higher. The primary is still used while it is not
+// failing when no processor qualifies.
+type ThresholdPolicy struct {
+	PrimaryMaxResponseTime   time.Duration
+	SecondaryMaxResponseTime time.Duration
+	MinSuccessRate           float64
+}
+
+func (p *ThresholdPolicy) Rank(candidates []Candidate) []string {
+	sorted := byPriority(candidates)
+
+	var ranked []string
+	for i, candidate := range sorted {
+		maxResponseTime := p.SecondaryMaxResponseTime
+		if i == 0 {
+			maxResponseTime = p.PrimaryMaxResponseTime
+		}
+
+		if !candidate.healthy() || candidate.SuccessRate < p.MinSuccessRate {
+			continue
+		}
+
+		latencyMs := max(float64(candidate.Health.MinResponseTime), candidate.LatencyMs)
+		if latencyMs <= float64(maxResponseTime.Milliseconds()) {
+			ranked = append(ranked, candidate.Name)
+		}
+	}
+
+	if len(sorted) > 0 && sorted[0].healthy() && !contains(ranked, sorted[0].Name) {
+		ranked = append(ranked, sorted[0].Name)
+	}
+
+	return ranked
+}
+
+// ProfitPolicy ranks processors by the expected profit of a payment sent to
+// them: what is left after the fee, weighted by the chance of success and
+// discounted by the expected latency relative to LatencyBudget, since slow
+// processors hold workers and delay every payment behind them. The expected
+// latency accounts for the p99 of real payments so a processor with a slow
+// tail loses to an equally fast one without it.
+type ProfitPolicy struct {
+	LatencyBudget time.Duration
+}
+
+func (p *ProfitPolicy) Rank(candidates []Candidate) []string {
+	type scored struct {
+		Candidate
+		score float64
+	}
+
+	var healthy []scored
+	for _, candidate := range byPriority(candidates) {
+		if !candidate.healthy() {
+			continue
+		}
+
+		healthy = append(healthy, scored{
+			Candidate: candidate,
+			score:     p.Score(candidate),
+		})
+	}
+
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return healthy[i].score > healthy[j].score
+	})
+
+	ranked := make([]string, 0, len(healthy))
+	for _, candidate := range healthy {
+		ranked = append(ranked, candidate.Name)
+	}
+
+	return ranked
+}
+
+// Score is the expected profit per unit of payment amount.
+func (p *ProfitPolicy) Score(candidate Candidate) float64 {
+	latencyMs := candidate.LatencyMs
+	if candidate.P99LatencyMs > 0 {
+		latencyMs = (candidate.LatencyMs + candidate.P99LatencyMs) / 2
+	}
+	if candidate.Health != nil && float64(candidate.Health.MinResponseTime) > latencyMs {
+		latencyMs = float64(candidate.Health.MinResponseTime)
+	}
+
+	budgetMs := float64(p.LatencyBudget.Milliseconds())
+	if budgetMs <= 0 {
+		budgetMs = 1
+	}
+
+	return (1 - candidate.Fee) * candidate.SuccessRate / (1 + latencyMs/budgetMs)
+}
+
+func byPriority(candidates []Candidate) []Candidate {
+	sorted := make([]Candidate, len(candidates))
+	copy(sorted, candidates)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	return sorted
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
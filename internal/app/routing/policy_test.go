@@ -0,0 +1,317 @@
+package routing
+
+import (
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func candidate(name string, fee float64, priority int, health *models.HealthCheck, latencyMs float64) Candidate {
+	return Candidate{
+		Name:        name,
+		Fee:         fee,
+		Priority:    priority,
+		Health:      health,
+		SuccessRate: 1,
+		LatencyMs:   latencyMs,
+	}
+}
+
+func healthy(minResponseTime int) *models.HealthCheck {
+	return &models.HealthCheck{MinResponseTime: minResponseTime}
+}
+
+func failing() *models.HealthCheck {
+	return &models.HealthCheck{IsFailing: true}
+}
+
+func TestProfitPolicyScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    time.Duration
+		candidate Candidate
+		want      float64
+	}{
+		{
+			name:      "fee only",
+			budget:    time.Second,
+			candidate: candidate("default", 0.05, 1, healthy(0), 0),
+			want:      0.95,
+		},
+		{
+			name:      "latency discounted by the budget",
+			budget:    time.Second,
+			candidate: candidate("default", 0.05, 1, healthy(0), 1000),
+			want:      0.95 / 2,
+		},
+		{
+			name:   "p99 averaged with the mean latency",
+			budget: time.Second,
+			candidate: Candidate{
+				Name: "default", Fee: 0.05, Health: healthy(0),
+				SuccessRate: 1, LatencyMs: 100, P99LatencyMs: 900,
+			},
+			want: 0.95 / 1.5,
+		},
+		{
+			name:      "minResponseTime is a latency floor",
+			budget:    time.Second,
+			candidate: candidate("default", 0.05, 1, healthy(500), 100),
+			want:      0.95 / 1.5,
+		},
+		{
+			name:   "weighted by the success rate",
+			budget: time.Second,
+			candidate: Candidate{
+				Name: "default", Fee: 0.05, Health: healthy(0),
+				SuccessRate: 0.5,
+			},
+			want: 0.95 * 0.5,
+		},
+		{
+			name:      "zero budget",
+			budget:    0,
+			candidate: candidate("default", 0.05, 1, healthy(0), 1),
+			want:      0.95 / 2,
+		},
+		{
+			name:      "budget under a millisecond",
+			budget:    time.Microsecond,
+			candidate: candidate("default", 0.05, 1, healthy(0), 1),
+			want:      0.95 / 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &ProfitPolicy{LatencyBudget: tt.budget}
+
+			got := policy.Score(tt.candidate)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfitPolicyRank(t *testing.T) {
+	tests := []struct {
+		name       string
+		budget     time.Duration
+		candidates []Candidate
+		want       []string
+	}{
+		{
+			name:   "cheaper processor wins at equal latency",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("fallback", 0.15, 2, healthy(10), 10),
+				candidate("default", 0.05, 1, healthy(10), 10),
+			},
+			want: []string{"default", "fallback"},
+		},
+		{
+			name:   "small latency gap does not outweigh the fee",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(0), 100),
+				candidate("fallback", 0.15, 2, healthy(0), 10),
+			},
+			want: []string{"default", "fallback"},
+		},
+		{
+			name:   "slow cheap processor loses to a fast expensive one",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(0), 500),
+				candidate("fallback", 0.15, 2, healthy(0), 10),
+			},
+			want: []string{"fallback", "default"},
+		},
+		{
+			name:   "failing processor is left out",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, failing(), 0),
+				candidate("fallback", 0.15, 2, healthy(0), 0),
+			},
+			want: []string{"fallback"},
+		},
+		{
+			name:   "processor never checked is left out",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, nil, 0),
+				candidate("fallback", 0.15, 2, healthy(0), 0),
+			},
+			want: []string{"fallback"},
+		},
+		{
+			name:   "every processor failing",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, failing(), 0),
+				candidate("fallback", 0.15, 2, failing(), 0),
+			},
+			want: []string{},
+		},
+		{
+			name:   "zero budget still prefers the faster processor",
+			budget: 0,
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(0), 50),
+				candidate("fallback", 0.15, 2, healthy(0), 5),
+			},
+			want: []string{"fallback", "default"},
+		},
+		{
+			name:   "ties keep the priority order",
+			budget: time.Second,
+			candidates: []Candidate{
+				candidate("third", 0.05, 3, healthy(10), 10),
+				candidate("first", 0.05, 1, healthy(10), 10),
+				candidate("second", 0.05, 2, healthy(10), 10),
+			},
+			want: []string{"first", "second", "third"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &ProfitPolicy{LatencyBudget: tt.budget}
+
+			if got := policy.Rank(tt.candidates); !slices.Equal(got, tt.want) {
+				t.Errorf("Rank() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholdPolicyRank(t *testing.T) {
+	policy := &ThresholdPolicy{
+		PrimaryMaxResponseTime:   300 * time.Millisecond,
+		SecondaryMaxResponseTime: 200 * time.Millisecond,
+		MinSuccessRate:           0.5,
+	}
+
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		want       []string
+	}{
+		{
+			name: "both under their thresholds",
+			candidates: []Candidate{
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+				candidate("default", 0.05, 1, healthy(250), 0),
+			},
+			want: []string{"default", "fallback"},
+		},
+		{
+			name: "primary at its threshold",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(300), 0),
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+			},
+			want: []string{"default", "fallback"},
+		},
+		{
+			name: "primary over its threshold is kept last",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(301), 0),
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+			},
+			want: []string{"fallback", "default"},
+		},
+		{
+			name: "secondary over its threshold is left out",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(250), 0),
+				candidate("fallback", 0.15, 2, healthy(250), 0),
+			},
+			want: []string{"default"},
+		},
+		{
+			name: "failing primary is left out",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, failing(), 0),
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+			},
+			want: []string{"fallback"},
+		},
+		{
+			name: "nothing fast enough falls back to the primary",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(1000), 0),
+				candidate("fallback", 0.15, 2, healthy(1000), 0),
+			},
+			want: []string{"default"},
+		},
+		{
+			name: "every processor failing",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, failing(), 0),
+				candidate("fallback", 0.15, 2, failing(), 0),
+			},
+			want: nil,
+		},
+		{
+			name: "primary failing real payments is kept last",
+			candidates: []Candidate{
+				{Name: "default", Fee: 0.05, Priority: 1, Health: healthy(10), SuccessRate: 0.2},
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+			},
+			want: []string{"fallback", "default"},
+		},
+		{
+			name: "secondary failing real payments is left out",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(10), 0),
+				{Name: "fallback", Fee: 0.15, Priority: 2, Health: healthy(10), SuccessRate: 0.2},
+			},
+			want: []string{"default"},
+		},
+		{
+			name: "success rate at the minimum qualifies",
+			candidates: []Candidate{
+				{Name: "default", Fee: 0.05, Priority: 1, Health: healthy(10), SuccessRate: 0.5},
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+			},
+			want: []string{"default", "fallback"},
+		},
+		{
+			name: "primary slow on real payments is kept last",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(10), 400),
+				candidate("fallback", 0.15, 2, healthy(100), 0),
+			},
+			want: []string{"fallback", "default"},
+		},
+		{
+			name: "secondary slow on real payments is left out",
+			candidates: []Candidate{
+				candidate("default", 0.05, 1, healthy(10), 0),
+				candidate("fallback", 0.15, 2, healthy(10), 250),
+			},
+			want: []string{"default"},
+		},
+		{
+			name: "priority ties keep the given order",
+			candidates: []Candidate{
+				candidate("a", 0.05, 1, healthy(10), 0),
+				candidate("b", 0.05, 1, healthy(10), 0),
+			},
+			want: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Rank(tt.candidates); !slices.Equal(got, tt.want) {
+				t.Errorf("Rank() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminTimestampHeader = "X-Admin-Timestamp"
+	adminSignatureHeader = "X-Admin-Signature"
+	adminMaxBodyBytes    = 1 << 20
+)
+
+var errAdminBodyTooLarge = fmt.Errorf("admin request body is too large")
+
+// AdminAuth only lets through requests carrying the configured admin bearer
+// token, or an HMAC-SHA256 signature made with the configured secret. The
+// signature is the hex digest of "<method>\n<request URI>\n<timestamp>\n<body>",
+// with the Unix timestamp sent in X-Admin-Timestamp and the digest in
+// X-Admin-Signature. Admin routes are disabled when neither is configured.
+func (h *Handlers) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cfg.AdminToken == "" && h.cfg.AdminHMACSecret == "" {
+			writeAdminProblem(w, http.StatusForbidden, "admin routes are disabled")
+			return
+		}
+
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && h.cfg.AdminToken != "" {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if r.Header.Get(adminSignatureHeader) != "" && h.cfg.AdminHMACSecret != "" {
+			ok, err := h.verifySignature(r)
+			if errors.Is(err, errAdminBodyTooLarge) {
+				writeAdminProblem(w, http.StatusRequestEntityTooLarge, "request body is too large")
+				return
+			}
+			if err != nil {
+				writeAdminProblem(w, http.StatusBadRequest, "request body could not be read")
+				return
+			}
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeAdminProblem(w, http.StatusUnauthorized, "missing or invalid admin credentials")
+	})
+}
+
+// verifySignature checks the request HMAC signature and timestamp, leaving the
+// body readable for the next handler.
+func (h *Handlers) verifySignature(r *http.Request) (bool, error) {
+	timestamp := r.Header.Get(adminTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > h.cfg.AdminHMACSkew || skew < -h.cfg.AdminHMACSkew {
+		return false, nil
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(adminSignatureHeader))
+	if err != nil {
+		return false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, adminMaxBodyBytes+1))
+	if err != nil {
+		return false, err
+	}
+	if len(body) > adminMaxBodyBytes {
+		return false, errAdminBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, []byte(h.cfg.AdminHMACSecret))
+	mac.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n" + timestamp + "\n"))
+	mac.Write(body)
+
+	return hmac.Equal(signature, mac.Sum(nil)), nil
+}
+
+func writeAdminProblem(w http.ResponseWriter, status int, detail string) {
+	writeProblem(w, models.Problem{
+		Type:   "/problems/admin_unauthorized",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
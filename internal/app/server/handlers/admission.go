@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// admit enqueues the payments. When the queue stays full they are spilled to
+// Redis, if enabled, and the worker pool queues them again once there is
+// room.
+func (h *Handlers) admit(ctx context.Context, payments ...*models.Payment) error {
+	var err error
+	if len(payments) == 1 {
+		err = h.paymentQueue.Enqueue(ctx, payments[0])
+	} else {
+		err = h.paymentQueue.EnqueueBatch(ctx, payments)
+	}
+
+	if !errors.Is(err, queue.ErrQueueFull) || !h.cfg.AdmissionSpill {
+		return err
+	}
+
+	if err := h.storageService.SaveUnprocessed(ctx, payments...); err != nil {
+		log.Printf("Error spilling %d payments to Redis: %v\n", len(payments), err)
+		return queue.ErrQueueFull
+	}
+
+	metrics.PaymentsSpilled.WithLabelValues("intake").Add(float64(len(payments)))
+	return nil
+}
+
+// rejectAdmission answers payments that could not be queued with 503 and a
+// Retry-After of the time the queue takes to drain at its current rate.
+func (h *Handlers) rejectAdmission(ctx context.Context, w http.ResponseWriter, err error, count int) {
+	countAdmissionRejections(err, count)
+
+	w.Header().Set("Retry-After", strconv.Itoa(h.retryAfter(ctx)))
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+func countAdmissionRejections(err error, count int) {
+	if errors.Is(err, queue.ErrQueueFull) {
+		metrics.PaymentsRejected.WithLabelValues("queue_full").Add(float64(count))
+	} else {
+		metrics.PaymentsRejected.WithLabelValues("queue_error").Add(float64(count))
+		log.Printf("Error enqueueing %d payments: %v\n", count, err)
+	}
+}
+
+// admissionReason is the reason reported for a batch payment that could not
+// be queued.
+func admissionReason(err error) string {
+	if errors.Is(err, queue.ErrQueueFull) {
+		return "payment queue is full, retry later"
+	}
+
+	return "payment could not be queued, retry later"
+}
+
+func (h *Handlers) retryAfter(ctx context.Context) int {
+	maxSeconds := int(math.Ceil(h.cfg.AdmissionRetryAfterMax.Seconds()))
+	if maxSeconds < 1 {
+		maxSeconds = 1
+	}
+
+	depth, err := h.paymentQueue.Len(ctx)
+	if err != nil {
+		return maxSeconds
+	}
+
+	rate := h.paymentQueue.DrainRate()
+	if rate <= 0 {
+		return maxSeconds
+	}
+
+	seconds := int(math.Ceil(float64(depth) / rate))
+	return max(1, min(seconds, maxSeconds))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bytedance/sonic"
+	"github.com/go-chi/chi/v5"
+)
+
+const defaultDeadLettersLimit = 50
+
+func (h *Handlers) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
+	if offset < 0 {
+		offset = 0
+	}
+
+	limit, err := strconv.ParseInt(query.Get("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultDeadLettersLimit
+	}
+
+	list, err := h.deadLetterService.List(r.Context(), offset, limit)
+	if err != nil {
+		log.Printf("Error listing dead letters: %v\n", err)
+		http.Error(w, "failed to list dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, list)
+}
+
+func (h *Handlers) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	deadLetter, err := h.deadLetterService.Get(r.Context(), chi.URLParam(r, "correlationId"))
+	if err != nil {
+		writeDeadLetterError(w, "failed to get dead letter", err)
+		return
+	}
+
+	writeJSON(w, deadLetter)
+}
+
+func (h *Handlers) RequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	charged, err := h.deadLetterService.Requeue(r.Context(), chi.URLParam(r, "correlationId"))
+	if err != nil {
+		writeDeadLetterError(w, "failed to requeue dead letter", err)
+		return
+	}
+
+	// Already charged by a processor, stored instead of queued
+	if charged != nil {
+		writeJSON(w, charged)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handlers) DiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if err := h.deadLetterService.Discard(r.Context(), chi.URLParam(r, "correlationId")); err != nil {
+		writeDeadLetterError(w, "failed to discard dead letter", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeDeadLetterError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, deadletter.ErrDeadLetterNotFound) {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Error handling dead letter: %v\n", err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	data, err := sonic.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding response: %v\n", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -1,21 +1,39 @@
 package handlers
 
 import (
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/idempotency"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/reconciliation"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/retry"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
 	"francoggm/rinhabackend-2025-go-redis/internal/config"
-	"francoggm/rinhabackend-2025-go-redis/internal/models"
 )
 
 type Handlers struct {
-	cfg            *config.Config
-	events         chan *models.Payment
-	storageService *storage.StorageService
+	cfg                   *config.Config
+	paymentQueue          queue.Queue
+	storageService        *storage.StorageService
+	idempotencyService    *idempotency.IdempotencyService
+	statusService         *status.StatusService
+	deadLetterService     *deadletter.DeadLetterService
+	retryScheduler        *retry.RetryScheduler
+	processors            []processor.Processor
+	reconciliationService *reconciliation.ReconciliationService
 }
 
-func NewHandlers(cfg *config.Config, events chan *models.Payment, storageService *storage.StorageService) *Handlers {
+func NewHandlers(cfg *config.Config, paymentQueue queue.Queue, storageService *storage.StorageService, idempotencyService *idempotency.IdempotencyService, statusService *status.StatusService, deadLetterService *deadletter.DeadLetterService, retryScheduler *retry.RetryScheduler, processors []processor.Processor, reconciliationService *reconciliation.ReconciliationService) *Handlers {
 	return &Handlers{
-		cfg:            cfg,
-		events:         events,
-		storageService: storageService,
+		cfg:                   cfg,
+		paymentQueue:          paymentQueue,
+		storageService:        storageService,
+		idempotencyService:    idempotencyService,
+		statusService:         statusService,
+		deadLetterService:     deadLetterService,
+		retryScheduler:        retryScheduler,
+		processors:            processors,
+		reconciliationService: reconciliationService,
 	}
 }
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) GetPaymentStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	correlationID := chi.URLParam(r, "correlationId")
+
+	paymentStatus, err := h.statusService.GetStatus(ctx, correlationID)
+	if err != nil {
+		log.Printf("Error getting payment status: %v\n", err)
+		http.Error(w, "failed to get payment status", http.StatusInternalServerError)
+		return
+	}
+
+	// Statuses expire before the stored payments do, so fall back to the
+	// payment record for old payments.
+	if paymentStatus == nil {
+		stored, err := h.storageService.GetPayment(ctx, correlationID)
+		if err != nil {
+			log.Printf("Error getting payment: %v\n", err)
+			http.Error(w, "failed to get payment status", http.StatusInternalServerError)
+			return
+		}
+
+		if stored == nil {
+			http.Error(w, "payment not found", http.StatusNotFound)
+			return
+		}
+
+		paymentStatus = &models.PaymentStatus{
+			CorrelationID: stored.CorrelationID,
+			Status:        models.PaymentSucceeded,
+			Amount:        stored.Amount,
+			Processor:     stored.ProcessingType,
+			UpdatedAt:     stored.RequestedAt,
+			Attempts:      []models.PaymentAttempt{},
+		}
+	}
+
+	writeJSON(w, paymentStatus)
+}
@@ -1,23 +1,79 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/idempotency"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
 	"net/http"
+
+	"github.com/bytedance/sonic"
 )
 
 func (h *Handlers) ProcessPayment(w http.ResponseWriter, r *http.Request) {
-	var payment models.Payment
-	err := json.NewDecoder(r.Body).Decode(&payment)
+	ctx := r.Context()
+
+	payment, rejection := h.decodePayment(w, r)
+	if rejection != nil {
+		rejectPayment(w, rejection)
+		return
+	}
+
+	result, stored, err := h.idempotencyService.Reserve(ctx, payment)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
+		log.Printf("Error reserving payment %s: %v\n", payment.CorrelationID, err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	select {
-	case h.events <- &payment:
+	switch result {
+	case idempotency.InFlight:
 		w.WriteHeader(http.StatusAccepted)
-	default:
-		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case idempotency.Conflict:
+		http.Error(w, "correlationId already used with a different amount", http.StatusConflict)
+		return
+	case idempotency.Failed, idempotency.Abandoned:
+		rejectPayment(w, terminatedPayment(result))
+		return
+	case idempotency.Completed:
+		data, err := sonic.Marshal(stored)
+		if err != nil {
+			log.Printf("Error encoding stored payment %s: %v\n", payment.CorrelationID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+
+	h.transitionPayment(ctx, payment, models.PaymentReceived)
+
+	if err := h.admit(ctx, payment); err != nil {
+		if err := h.idempotencyService.Release(ctx, payment.CorrelationID); err != nil {
+			log.Printf("Error releasing payment %s reservation: %v\n", payment.CorrelationID, err)
+		}
+
+		if err := h.statusService.Forget(ctx, payment.CorrelationID); err != nil {
+			log.Printf("Error removing payment %s status: %v\n", payment.CorrelationID, err)
+		}
+
+		h.rejectAdmission(ctx, w, err, 1)
+		return
+	}
+
+	h.transitionPayment(ctx, payment, models.PaymentQueued)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handlers) transitionPayment(ctx context.Context, payment *models.Payment, state models.PaymentState) {
+	err := h.statusService.Transition(ctx, payment, state, nil)
+	if err != nil && !errors.Is(err, status.ErrInvalidTransition) {
+		log.Printf("Error setting payment %s status to %s: %v\n", payment.CorrelationID, state, err)
 	}
 }
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/idempotency"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	batchModePartial = "partial"
+	batchModeAtomic  = "atomic"
+)
+
+// ProcessPaymentBatch accepts a JSON array or an NDJSON stream of payments.
+// In partial mode (the default) every valid payment is accepted on its own,
+// even when others are rejected or do not fit in the queue; with ?mode=atomic
+// any rejected payment rejects the whole batch. Payments already submitted
+// are reported as duplicates and never enqueued twice.
+func (h *Handlers) ProcessPaymentBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = batchModePartial
+	}
+	if mode != batchModePartial && mode != batchModeAtomic {
+		rejectPayment(w, newValidationError(http.StatusBadRequest, reasonInvalidBatch, "mode must be partial or atomic"))
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.cfg.PaymentBatchMaxBodyBytes)))
+	if err != nil {
+		rejectPayment(w, decodeError(err))
+		return
+	}
+
+	items, rejection := splitBatch(data)
+	if rejection != nil {
+		rejectPayment(w, rejection)
+		return
+	}
+	if len(items) > h.cfg.PaymentBatchMaxItems {
+		rejectPayment(w, newValidationError(http.StatusRequestEntityTooLarge, reasonInvalidBatch,
+			fmt.Sprintf("batch exceeds %d payments", h.cfg.PaymentBatchMaxItems)))
+		return
+	}
+
+	result := &models.BatchResult{
+		Mode:  mode,
+		Items: make([]models.BatchItemResult, len(items)),
+	}
+
+	payments := make([]*models.Payment, len(items))
+	seen := make(map[string]bool, len(items))
+
+	for i, item := range items {
+		result.Items[i].Index = i
+
+		payment, rejection := h.parsePayment(item)
+		if rejection != nil {
+			for _, reason := range rejection.reasons {
+				metrics.PaymentsRejected.WithLabelValues(reason).Inc()
+			}
+			result.Items[i].Status = models.BatchItemRejected
+			result.Items[i].Reason = rejection.problem.Detail
+			result.Items[i].InvalidParams = rejection.problem.InvalidParams
+			continue
+		}
+
+		result.Items[i].CorrelationID = payment.CorrelationID
+		if seen[payment.CorrelationID] {
+			result.Items[i].Status = models.BatchItemDuplicate
+			result.Items[i].Reason = "correlationId repeated in batch"
+			continue
+		}
+
+		seen[payment.CorrelationID] = true
+		payments[i] = payment
+	}
+
+	if mode == batchModeAtomic && countBatch(result, models.BatchItemRejected) > 0 {
+		abortBatch(w, result, payments)
+		return
+	}
+
+	var reserved []*models.Payment
+	reservedIndexes := make([]int, 0, len(items))
+
+	for i, payment := range payments {
+		if payment == nil {
+			continue
+		}
+
+		status, _, err := h.idempotencyService.Reserve(ctx, payment)
+		if err != nil {
+			log.Printf("Error reserving payment %s: %v\n", payment.CorrelationID, err)
+			h.releaseBatch(ctx, reserved)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		switch status {
+		case idempotency.Reserved:
+			reserved = append(reserved, payment)
+			reservedIndexes = append(reservedIndexes, i)
+		case idempotency.InFlight, idempotency.Completed:
+			result.Items[i].Status = models.BatchItemDuplicate
+			result.Items[i].Reason = "correlationId already submitted"
+			payments[i] = nil
+		case idempotency.Conflict:
+			metrics.PaymentsRejected.WithLabelValues(reasonAmountConflict).Inc()
+			result.Items[i].Status = models.BatchItemRejected
+			result.Items[i].Reason = "correlationId already used with a different amount"
+			payments[i] = nil
+		case idempotency.Failed, idempotency.Abandoned:
+			rejection := terminatedPayment(status)
+			metrics.PaymentsRejected.WithLabelValues(rejection.reasons[0]).Inc()
+			result.Items[i].Status = models.BatchItemRejected
+			result.Items[i].Reason = rejection.problem.Detail
+			payments[i] = nil
+		}
+	}
+
+	if mode == batchModeAtomic && countBatch(result, models.BatchItemRejected) > 0 {
+		h.releaseBatch(ctx, reserved)
+		abortBatch(w, result, payments)
+		return
+	}
+
+	if mode == batchModeAtomic {
+		h.admitAtomic(ctx, w, result, reserved, reservedIndexes)
+		return
+	}
+
+	h.admitPartial(ctx, w, result, payments, reservedIndexes)
+}
+
+// admitAtomic enqueues the reserved payments of an atomic batch all together,
+// rejecting the whole batch when they do not fit.
+func (h *Handlers) admitAtomic(ctx context.Context, w http.ResponseWriter, result *models.BatchResult, reserved []*models.Payment, reservedIndexes []int) {
+	if len(reserved) > 0 {
+		for _, payment := range reserved {
+			h.transitionPayment(ctx, payment, models.PaymentReceived)
+		}
+
+		if err := h.admit(ctx, reserved...); err != nil {
+			h.unreserveBatch(ctx, reserved)
+			h.rejectAdmission(ctx, w, err, len(reserved))
+			return
+		}
+
+		for _, payment := range reserved {
+			h.transitionPayment(ctx, payment, models.PaymentQueued)
+		}
+	}
+
+	for _, i := range reservedIndexes {
+		result.Items[i].Status = models.BatchItemAccepted
+	}
+
+	writeBatchResult(w, http.StatusOK, result)
+}
+
+// admitPartial enqueues the reserved payments one by one, reporting those that
+// could not be queued as rejected. Once the queue is full the payments left
+// are rejected without waiting for room again. The response carries a
+// Retry-After when any payment could not be queued.
+func (h *Handlers) admitPartial(ctx context.Context, w http.ResponseWriter, result *models.BatchResult, payments []*models.Payment, reservedIndexes []int) {
+	var queueFull error
+	rejected := false
+
+	for _, i := range reservedIndexes {
+		payment := payments[i]
+
+		err := queueFull
+		if err == nil {
+			h.transitionPayment(ctx, payment, models.PaymentReceived)
+			err = h.admit(ctx, payment)
+		}
+
+		if err != nil {
+			h.unreserveBatch(ctx, []*models.Payment{payment})
+			countAdmissionRejections(err, 1)
+
+			result.Items[i].Status = models.BatchItemRejected
+			result.Items[i].Reason = admissionReason(err)
+			if errors.Is(err, queue.ErrQueueFull) {
+				queueFull = err
+			}
+			rejected = true
+			continue
+		}
+
+		h.transitionPayment(ctx, payment, models.PaymentQueued)
+		result.Items[i].Status = models.BatchItemAccepted
+	}
+
+	if rejected {
+		w.Header().Set("Retry-After", strconv.Itoa(h.retryAfter(ctx)))
+	}
+
+	writeBatchResult(w, http.StatusOK, result)
+}
+
+// splitBatch returns the raw payments of a JSON array or of an NDJSON stream,
+// one payment per non-empty line.
+func splitBatch(data []byte) ([][]byte, *validationError) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, newValidationError(http.StatusBadRequest, reasonInvalidBatch, "batch has no payments")
+	}
+
+	var items [][]byte
+	if data[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, newValidationError(http.StatusBadRequest, reasonMalformedJSON, "request body is not a valid JSON array")
+		}
+
+		for _, item := range raw {
+			items = append(items, item)
+		}
+	} else {
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) > 0 {
+				items = append(items, line)
+			}
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, newValidationError(http.StatusBadRequest, reasonInvalidBatch, "batch has no payments")
+	}
+
+	return items, nil
+}
+
+func (h *Handlers) releaseBatch(ctx context.Context, payments []*models.Payment) {
+	for _, payment := range payments {
+		if err := h.idempotencyService.Release(ctx, payment.CorrelationID); err != nil {
+			log.Printf("Error releasing payment %s reservation: %v\n", payment.CorrelationID, err)
+		}
+	}
+}
+
+// unreserveBatch releases the payments that could not be queued and drops
+// their status, so they can be submitted again.
+func (h *Handlers) unreserveBatch(ctx context.Context, payments []*models.Payment) {
+	h.releaseBatch(ctx, payments)
+
+	for _, payment := range payments {
+		if err := h.statusService.Forget(ctx, payment.CorrelationID); err != nil {
+			log.Printf("Error removing payment %s status: %v\n", payment.CorrelationID, err)
+		}
+	}
+}
+
+// abortBatch answers an atomic batch with a rejected payment, marking the
+// valid payments as aborted.
+func abortBatch(w http.ResponseWriter, result *models.BatchResult, payments []*models.Payment) {
+	for i, payment := range payments {
+		if payment != nil {
+			result.Items[i].Status = models.BatchItemAborted
+			result.Items[i].Reason = "batch has rejected payments"
+		}
+	}
+
+	writeBatchResult(w, http.StatusUnprocessableEntity, result)
+}
+
+func countBatch(result *models.BatchResult, status models.BatchItemStatus) int {
+	count := 0
+	for _, item := range result.Items {
+		if item.Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+func writeBatchResult(w http.ResponseWriter, status int, result *models.BatchResult) {
+	result.Accepted = countBatch(result, models.BatchItemAccepted)
+	result.Duplicates = countBatch(result, models.BatchItemDuplicate)
+	result.Rejected = countBatch(result, models.BatchItemRejected)
+
+	data, err := sonic.Marshal(result)
+	if err != nil {
+		log.Println("Error encoding batch result:", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -1,42 +1,117 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/bytedance/sonic"
 )
 
+// PurgePayments deletes every payment from the processors and from Redis,
+// answering with how much was deleted. With ?dryRun=true nothing is deleted
+// and the report tells what would be.
 func (h *Handlers) PurgePayments(w http.ResponseWriter, r *http.Request) {
-	if err := purgeProcessor(h.cfg.PaymentProcessorConfig.DefaultURL + "/admin/purge-payments"); err != nil {
-		http.Error(w, fmt.Sprintf("failed to prune default payments: %v", err), http.StatusBadGateway)
-		return
+	ctx := r.Context()
+
+	dryRun := false
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "dryRun must be a boolean", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
 	}
 
-	if err := purgeProcessor(h.cfg.PaymentProcessorConfig.FallbackURL + "/admin/purge-payments"); err != nil {
-		http.Error(w, fmt.Sprintf("failed to prune fallback payments: %v", err), http.StatusBadGateway)
+	report, err := h.purgeReport(ctx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to count payments: %v", err), http.StatusBadGateway)
 		return
 	}
+	report.DryRun = dryRun
 
-	if err := h.storageService.PurgePayments(r.Context()); err != nil {
-		http.Error(w, fmt.Sprintf("failed to prune storage payments: %v", err), http.StatusBadGateway)
+	if !dryRun {
+		if err := h.purge(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	data, err := sonic.Marshal(report)
+	if err != nil {
+		log.Println("Error encoding purge report:", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
-func purgeProcessor(url string) error {
-	req, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return err
+func (h *Handlers) purgeReport(ctx context.Context) (*models.PurgeReport, error) {
+	report := &models.PurgeReport{
+		Processors: make([]string, 0, len(h.processors)),
+	}
+	for _, processor := range h.processors {
+		report.Processors = append(report.Processors, processor.Name())
 	}
-	req.Header.Set("X-Rinha-Token", "123")
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	var err error
+	if report.Payments, report.UnprocessedPayments, err = h.storageService.CountPayments(ctx); err != nil {
+		return nil, err
+	}
+	if report.QueuedPayments, err = h.paymentQueue.Len(ctx); err != nil {
+		return nil, err
+	}
+	if report.IdempotencyKeys, err = h.idempotencyService.Count(ctx); err != nil {
+		return nil, err
+	}
+	if report.PaymentStatuses, err = h.statusService.Count(ctx); err != nil {
+		return nil, err
+	}
+	if report.DeadLetters, err = h.deadLetterService.Count(ctx); err != nil {
+		return nil, err
+	}
+	if report.Retries, err = h.retryScheduler.Len(ctx); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func (h *Handlers) purge(ctx context.Context) error {
+	for _, processor := range h.processors {
+		if err := processor.PurgePayments(); err != nil {
+			return fmt.Errorf("failed to prune %s payments: %w", processor.Name(), err)
+		}
+	}
+
+	if err := h.storageService.PurgePayments(ctx); err != nil {
+		return fmt.Errorf("failed to prune storage payments: %w", err)
+	}
+
+	if err := h.paymentQueue.Purge(ctx); err != nil {
+		return fmt.Errorf("failed to prune queued payments: %w", err)
+	}
+
+	if err := h.idempotencyService.Purge(ctx); err != nil {
+		return fmt.Errorf("failed to prune idempotency keys: %w", err)
+	}
+
+	if err := h.statusService.Purge(ctx); err != nil {
+		return fmt.Errorf("failed to prune payment statuses: %w", err)
+	}
+
+	if err := h.deadLetterService.Purge(ctx); err != nil {
+		return fmt.Errorf("failed to prune dead letters: %w", err)
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to prune payments, status code: %d", res.StatusCode)
+	if err := h.retryScheduler.Purge(ctx); err != nil {
+		return fmt.Errorf("failed to prune retries: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/reconciliation"
+	"log"
+	"net/http"
+	"time"
+)
+
+// GetReconciliation returns the last report of the reconciliation job.
+func (h *Handlers) GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	report, err := h.reconciliationService.Latest(r.Context())
+	if errors.Is(err, reconciliation.ErrNoReport) {
+		http.Error(w, "no reconciliation report yet", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting reconciliation report: %v\n", err)
+		http.Error(w, "failed to get reconciliation report", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+// RunReconciliation reconciles the from and to window now, the job's window
+// when both are omitted, and saves the report as the latest one.
+func (h *Handlers) RunReconciliation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	from, to := h.reconciliationService.Window(time.Now())
+	if query.Get("from") != "" || query.Get("to") != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339Nano, query.Get("from")); err != nil {
+			http.Error(w, "from must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		if to, err = time.Parse(time.RFC3339Nano, query.Get("to")); err != nil {
+			http.Error(w, "to must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.reconciliationService.Reconcile(ctx, &from, &to)
+	if err != nil {
+		log.Printf("Error reconciling payments: %v\n", err)
+		http.Error(w, "failed to reconcile payments", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.reconciliationService.Save(ctx, report); err != nil {
+		log.Printf("Error saving reconciliation report: %v\n", err)
+	}
+
+	writeJSON(w, report)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const defaultRetriesLimit = 50
+
+func (h *Handlers) ListRetries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
+	if offset < 0 {
+		offset = 0
+	}
+
+	limit, err := strconv.ParseInt(query.Get("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultRetriesLimit
+	}
+
+	list, err := h.retryScheduler.List(r.Context(), offset, limit)
+	if err != nil {
+		log.Printf("Error listing retries: %v\n", err)
+		http.Error(w, "failed to list retries", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, list)
+}
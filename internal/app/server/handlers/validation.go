@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/idempotency"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+)
+
+// Rejection reasons, used as the metrics label and the problem type.
+const (
+	reasonBodyTooLarge         = "body_too_large"
+	reasonMalformedJSON        = "malformed_json"
+	reasonUnknownField         = "unknown_field"
+	reasonInvalidCorrelationID = "invalid_correlation_id"
+	reasonInvalidAmount        = "invalid_amount"
+	reasonInvalidBatch         = "invalid_batch"
+	reasonAmountConflict       = "amount_conflict"
+	reasonPaymentFailed        = "payment_failed"
+	reasonPaymentAbandoned     = "payment_abandoned"
+)
+
+// paymentRequest is the body accepted by POST /payments. Amount is a pointer
+// so a missing amount is told apart from a zero one.
+type paymentRequest struct {
+	CorrelationID string        `json:"correlationId"`
+	Amount        *models.Money `json:"amount"`
+}
+
+// validationError is a rejected payment request, carrying the problem
+// reported to the client and the reasons counted in metrics.
+type validationError struct {
+	problem models.Problem
+	reasons []string
+}
+
+func (e *validationError) Error() string {
+	return e.problem.Detail
+}
+
+func newValidationError(status int, reason, detail string) *validationError {
+	return &validationError{
+		problem: models.Problem{
+			Type:   "/problems/" + reason,
+			Title:  http.StatusText(status),
+			Status: status,
+			Detail: detail,
+		},
+		reasons: []string{reason},
+	}
+}
+
+// decodePayment reads and validates a single payment from the request body.
+func (h *Handlers) decodePayment(w http.ResponseWriter, r *http.Request) (*models.Payment, *validationError) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.cfg.PaymentMaxBodyBytes)))
+	if err != nil {
+		return nil, decodeError(err)
+	}
+
+	return h.parsePayment(data)
+}
+
+// parsePayment decodes and validates a single JSON payment.
+func (h *Handlers) parsePayment(data []byte) (*models.Payment, *validationError) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var request paymentRequest
+	if err := decoder.Decode(&request); err != nil {
+		return nil, decodeError(err)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, newValidationError(http.StatusBadRequest, reasonMalformedJSON, "request body must contain a single payment")
+	}
+
+	return h.validatePayment(request)
+}
+
+// validatePayment checks every field of the request, reporting all invalid
+// fields at once.
+func (h *Handlers) validatePayment(request paymentRequest) (*models.Payment, *validationError) {
+	var params []models.InvalidParam
+	var reasons []string
+
+	if request.CorrelationID == "" {
+		params = append(params, models.InvalidParam{Name: "correlationId", Reason: "is required"})
+		reasons = append(reasons, reasonInvalidCorrelationID)
+	} else if _, err := uuid.Parse(request.CorrelationID); err != nil || len(request.CorrelationID) != 36 {
+		params = append(params, models.InvalidParam{Name: "correlationId", Reason: "must be a UUID"})
+		reasons = append(reasons, reasonInvalidCorrelationID)
+	}
+
+	switch {
+	case request.Amount == nil:
+		params = append(params, models.InvalidParam{Name: "amount", Reason: "is required"})
+		reasons = append(reasons, reasonInvalidAmount)
+	case *request.Amount <= 0:
+		params = append(params, models.InvalidParam{Name: "amount", Reason: "must be positive"})
+		reasons = append(reasons, reasonInvalidAmount)
+	case *request.Amount > h.cfg.PaymentMaxAmount:
+		params = append(params, models.InvalidParam{Name: "amount", Reason: fmt.Sprintf("must not exceed %s", h.cfg.PaymentMaxAmount)})
+		reasons = append(reasons, reasonInvalidAmount)
+	}
+
+	if len(params) > 0 {
+		return nil, &validationError{
+			problem: models.Problem{
+				Type:          "/problems/invalid_payment",
+				Title:         http.StatusText(http.StatusUnprocessableEntity),
+				Status:        http.StatusUnprocessableEntity,
+				Detail:        "payment has invalid fields",
+				InvalidParams: params,
+			},
+			reasons: reasons,
+		}
+	}
+
+	return &models.Payment{
+		CorrelationID: request.CorrelationID,
+		Amount:        *request.Amount,
+	}, nil
+}
+
+func decodeError(err error) *validationError {
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return newValidationError(http.StatusRequestEntityTooLarge, reasonBodyTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, models.ErrInvalidAmount), errors.Is(err, models.ErrAmountPrecision):
+		rejection := newValidationError(http.StatusUnprocessableEntity, reasonInvalidAmount, "payment has invalid fields")
+		rejection.problem.InvalidParams = []models.InvalidParam{{Name: "amount", Reason: err.Error()}}
+		return rejection
+	case errors.As(err, &typeErr):
+		rejection := newValidationError(http.StatusBadRequest, reasonMalformedJSON, "payment has fields of the wrong type")
+		rejection.problem.InvalidParams = []models.InvalidParam{{Name: typeErr.Field, Reason: "must be a " + typeErr.Type.String()}}
+		return rejection
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		rejection := newValidationError(http.StatusBadRequest, reasonUnknownField, "payment has unknown fields")
+		rejection.problem.InvalidParams = []models.InvalidParam{{Name: field, Reason: "is not allowed"}}
+		return rejection
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return newValidationError(http.StatusBadRequest, reasonMalformedJSON, "request body is not a valid JSON payment")
+	}
+
+	return newValidationError(http.StatusBadRequest, reasonMalformedJSON, err.Error())
+}
+
+// terminatedPayment is the rejection of a duplicate of a payment that failed
+// or was abandoned, reported with how it ended.
+func terminatedPayment(result idempotency.Result) *validationError {
+	if result == idempotency.Abandoned {
+		return newValidationError(http.StatusUnprocessableEntity, reasonPaymentAbandoned,
+			"payment with this correlationId was abandoned after its retries and is kept as a dead letter")
+	}
+
+	return newValidationError(http.StatusUnprocessableEntity, reasonPaymentFailed,
+		"payment with this correlationId failed and is kept as a dead letter")
+}
+
+// rejectPayment counts the rejection and writes its problem details.
+func rejectPayment(w http.ResponseWriter, rejection *validationError) {
+	for _, reason := range rejection.reasons {
+		metrics.PaymentsRejected.WithLabelValues(reason).Inc()
+	}
+
+	writeProblem(w, rejection.problem)
+}
+
+func writeProblem(w http.ResponseWriter, problem models.Problem) {
+	data, err := sonic.Marshal(problem)
+	if err != nil {
+		http.Error(w, problem.Title, problem.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	w.Write(data)
+}
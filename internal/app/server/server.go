@@ -1,11 +1,20 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/idempotency"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/processor"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/reconciliation"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/retry"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/server/handlers"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
 	"francoggm/rinhabackend-2025-go-redis/internal/config"
-	"francoggm/rinhabackend-2025-go-redis/internal/models"
 	"net/http"
 	"time"
 
@@ -16,33 +25,70 @@ type Server struct {
 	cfg      *config.Config
 	router   *chi.Mux
 	handlers *handlers.Handlers
+	httpSrv  *http.Server
 }
 
-func NewServer(cfg *config.Config, events chan *models.Payment, storageService *storage.StorageService) *Server {
+func NewServer(cfg *config.Config, paymentQueue queue.Queue, storageService *storage.StorageService, idempotencyService *idempotency.IdempotencyService, statusService *status.StatusService, deadLetterService *deadletter.DeadLetterService, retryScheduler *retry.RetryScheduler, processors []processor.Processor, reconciliationService *reconciliation.ReconciliationService) *Server {
 	srv := &Server{
 		cfg:      cfg,
 		router:   chi.NewRouter(),
-		handlers: handlers.NewHandlers(cfg, events, storageService),
+		handlers: handlers.NewHandlers(cfg, paymentQueue, storageService, idempotencyService, statusService, deadLetterService, retryScheduler, processors, reconciliationService),
 	}
 
 	srv.registerRoutes()
+
+	srv.httpSrv = &http.Server{
+		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
+		Handler:      srv.router,
+		IdleTimeout:  15 * time.Second,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
 	return srv
 }
 
 func (s *Server) registerRoutes() {
 	s.router.Post("/payments", s.handlers.ProcessPayment)
+	s.router.Post("/payments/batch", s.handlers.ProcessPaymentBatch)
+	s.router.Get("/payments/{correlationId}", s.handlers.GetPaymentStatus)
 	s.router.Get("/payments-summary", s.handlers.GetPaymentsSummary)
-	s.router.Post("/purge-payments", s.handlers.PurgePayments)
+	s.router.With(s.handlers.AdminAuth).Post("/purge-payments", s.handlers.PurgePayments)
+	s.router.Handle("/metrics", metrics.Handler())
+
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Use(s.handlers.AdminAuth)
+
+		r.Post("/purge-payments", s.handlers.PurgePayments)
+
+		r.Get("/dead-letters", s.handlers.ListDeadLetters)
+		r.Get("/dead-letters/{correlationId}", s.handlers.GetDeadLetter)
+		r.Post("/dead-letters/{correlationId}/requeue", s.handlers.RequeueDeadLetter)
+		r.Delete("/dead-letters/{correlationId}", s.handlers.DiscardDeadLetter)
+
+		r.Get("/retries", s.handlers.ListRetries)
+
+		r.Get("/reconciliation", s.handlers.GetReconciliation)
+		r.Post("/reconciliation", s.handlers.RunReconciliation)
+	})
+}
+
+// Handler returns the router serving every route, e.g. to serve it from a
+// test server.
+func (s *Server) Handler() http.Handler {
+	return s.router
 }
 
 func (s *Server) Run() error {
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%s", s.cfg.Server.Port),
-		Handler:      s.router,
-		IdleTimeout:  15 * time.Second,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	if err := s.httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
 
-	return srv.ListenAndServe()
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish until ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpSrv.Shutdown(ctx)
 }
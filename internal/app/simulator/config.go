@@ -0,0 +1,115 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Name string
+	// Fee is the fraction of each payment reported as fee in the summary.
+	Fee   float64
+	Token string
+
+	// ErrorRate is the fraction of payments answered with a 500 without
+	// being recorded.
+	ErrorRate float64
+	// AmbiguousRate is the fraction of payments recorded but answered only
+	// after AmbiguousDelay, so a client with a shorter timeout does not know
+	// whether they were charged.
+	AmbiguousRate  float64
+	AmbiguousDelay time.Duration
+
+	Latency Latency
+	// Outages are windows, relative to the simulator start, during which
+	// every payment fails and the health check reports failing.
+	Outages []Outage
+
+	// HealthRateLimit is the minimum interval between two health checks,
+	// more frequent calls get a 429. Zero disables the limit.
+	HealthRateLimit time.Duration
+}
+
+// Latency is the distribution of the time taken to answer a payment:
+// "fixed" always takes Min, "uniform" is uniform between Min and Max and
+// "exponential" adds to Min an exponential tail with a mean of a quarter of
+// Max-Min, capped at Max.
+type Latency struct {
+	Distribution string
+	Min          time.Duration
+	Max          time.Duration
+}
+
+func (l Latency) Validate() error {
+	switch l.Distribution {
+	case "fixed", "uniform", "exponential":
+	default:
+		return fmt.Errorf("unknown latency distribution: %s", l.Distribution)
+	}
+
+	if l.Min < 0 || (l.Distribution != "fixed" && l.Max < l.Min) {
+		return fmt.Errorf("invalid latency range: %s-%s", l.Min, l.Max)
+	}
+
+	return nil
+}
+
+func (l Latency) Sample() time.Duration {
+	spread := l.Max - l.Min
+	if spread <= 0 {
+		return l.Min
+	}
+
+	switch l.Distribution {
+	case "uniform":
+		return l.Min + rand.N(spread)
+	case "exponential":
+		tail := time.Duration(rand.ExpFloat64() * float64(spread) / 4)
+		return l.Min + min(tail, spread)
+	}
+
+	return l.Min
+}
+
+type Outage struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseOutages parses outage windows written as "start-end" durations
+// separated by commas, e.g. "10s-20s,1m-1m30s".
+func ParseOutages(value string) ([]Outage, error) {
+	var outages []Outage
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		startValue, endValue, ok := strings.Cut(entry, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid outage %q, expected start-end", entry)
+		}
+
+		start, err := time.ParseDuration(startValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outage %q start: %w", entry, err)
+		}
+
+		end, err := time.ParseDuration(endValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outage %q end: %w", entry, err)
+		}
+
+		if end <= start {
+			return nil, fmt.Errorf("invalid outage %q, end must be after start", entry)
+		}
+
+		outages = append(outages, Outage{Start: start, End: end})
+	}
+
+	return outages, nil
+}
@@ -0,0 +1,357 @@
+package simulator
+
+import (
+	"context"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/go-chi/chi/v5"
+)
+
+// Simulator is an in-memory payment processor implementing the Rinha payment
+// processor HTTP API, with scriptable failures, so the service can run and be
+// tested without the official processors.
+type Simulator struct {
+	cfg       Config
+	startedAt time.Time
+	router    *chi.Mux
+
+	mutex      sync.Mutex
+	payments   map[string]models.Payment
+	failure    bool
+	delay      time.Duration
+	token      string
+	errorRate  float64
+	lastHealth time.Time
+}
+
+func NewSimulator(cfg Config) *Simulator {
+	s := &Simulator{
+		cfg:       cfg,
+		startedAt: time.Now(),
+		router:    chi.NewRouter(),
+		payments:  make(map[string]models.Payment),
+		token:     cfg.Token,
+		errorRate: cfg.ErrorRate,
+	}
+
+	s.router.Post("/payments", s.processPayment)
+	s.router.Get("/payments/service-health", s.serviceHealth)
+	s.router.Get("/payments/{correlationId}", s.getPayment)
+
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Use(s.authenticate)
+
+		r.Get("/payments-summary", s.paymentsSummary)
+		r.Post("/purge-payments", s.purgePayments)
+		r.Put("/configurations/failure", s.configureFailure)
+		r.Put("/configurations/delay", s.configureDelay)
+		r.Put("/configurations/token", s.configureToken)
+		r.Put("/configurations/error-rate", s.configureErrorRate)
+	})
+
+	return s
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// SetFailure makes every payment fail and the health check report failing
+// until it is called again with false.
+func (s *Simulator) SetFailure(failure bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failure = failure
+}
+
+// SetDelay adds delay to the latency of every payment.
+func (s *Simulator) SetDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.delay = delay
+}
+
+func (s *Simulator) SetErrorRate(rate float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errorRate = rate
+}
+
+// Payment returns the payment recorded with correlationID.
+func (s *Simulator) Payment(correlationID string) (models.Payment, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	payment, ok := s.payments[correlationID]
+	return payment, ok
+}
+
+// Summary returns the totals of the payments requested within from and to,
+// both optional, as reported on /admin/payments-summary.
+func (s *Simulator) Summary(from, to *time.Time) models.ProcessorSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	summary := models.ProcessorSummary{FeePerTransaction: s.cfg.Fee}
+	for _, payment := range s.payments {
+		if from != nil && payment.RequestedAt.Before(*from) {
+			continue
+		}
+		if to != nil && payment.RequestedAt.After(*to) {
+			continue
+		}
+
+		summary.TotalRequests++
+		summary.TotalAmount += payment.Amount
+	}
+	summary.TotalFee = models.Money(math.Round(float64(summary.TotalAmount) * s.cfg.Fee))
+
+	return summary
+}
+
+func (s *Simulator) failing() bool {
+	if s.failure {
+		return true
+	}
+
+	elapsed := time.Since(s.startedAt)
+	for _, outage := range s.cfg.Outages {
+		if elapsed >= outage.Start && elapsed < outage.End {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Simulator) processPayment(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	failing, delay, errorRate := s.failing(), s.delay, s.errorRate
+	s.mutex.Unlock()
+
+	if !sleep(r.Context(), s.cfg.Latency.Sample()+delay) {
+		return
+	}
+
+	if failing || rand.Float64() < errorRate {
+		writeMessage(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	var payment models.Payment
+	if err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(&payment); err != nil {
+		writeMessage(w, http.StatusBadRequest, "invalid payment")
+		return
+	}
+	if payment.CorrelationID == "" || payment.Amount <= 0 || payment.RequestedAt.IsZero() {
+		writeMessage(w, http.StatusUnprocessableEntity, "invalid payment")
+		return
+	}
+	payment.ProcessingType = ""
+
+	s.mutex.Lock()
+	_, duplicate := s.payments[payment.CorrelationID]
+	if !duplicate {
+		s.payments[payment.CorrelationID] = payment
+	}
+	s.mutex.Unlock()
+
+	if duplicate {
+		writeMessage(w, http.StatusUnprocessableEntity, "payment already processed")
+		return
+	}
+
+	if rand.Float64() < s.cfg.AmbiguousRate && !sleep(r.Context(), s.cfg.AmbiguousDelay) {
+		return
+	}
+
+	writeMessage(w, http.StatusOK, "payment processed successfully")
+}
+
+func (s *Simulator) serviceHealth(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	if s.cfg.HealthRateLimit > 0 && time.Since(s.lastHealth) < s.cfg.HealthRateLimit {
+		s.mutex.Unlock()
+		writeMessage(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+	s.lastHealth = time.Now()
+
+	health := models.HealthCheck{
+		IsFailing:       s.failing(),
+		MinResponseTime: int((s.cfg.Latency.Min + s.delay).Milliseconds()),
+	}
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, health)
+}
+
+func (s *Simulator) getPayment(w http.ResponseWriter, r *http.Request) {
+	payment, ok := s.Payment(chi.URLParam(r, "correlationId"))
+	if !ok {
+		writeMessage(w, http.StatusNotFound, "payment not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, payment)
+}
+
+func (s *Simulator) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		token := s.token
+		s.mutex.Unlock()
+
+		if r.Header.Get("X-Rinha-Token") != token {
+			writeMessage(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Simulator) paymentsSummary(w http.ResponseWriter, r *http.Request) {
+	from, err := parseBound(r.URL.Query().Get("from"))
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "invalid from")
+		return
+	}
+
+	to, err := parseBound(r.URL.Query().Get("to"))
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "invalid to")
+		return
+	}
+
+	summary := s.Summary(from, to)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"totalRequests":     summary.TotalRequests,
+		"totalAmount":       float64(summary.TotalAmount) / 100,
+		"totalFee":          float64(summary.TotalFee) / 100,
+		"feePerTransaction": summary.FeePerTransaction,
+	})
+}
+
+func (s *Simulator) purgePayments(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.payments = make(map[string]models.Payment)
+	s.mutex.Unlock()
+
+	writeMessage(w, http.StatusOK, "All payments purged.")
+}
+
+func (s *Simulator) configureFailure(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Failure bool `json:"failure"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	s.SetFailure(body.Failure)
+	writeMessage(w, http.StatusOK, "failure configured")
+}
+
+func (s *Simulator) configureDelay(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Delay int `json:"delay"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	s.SetDelay(time.Duration(body.Delay) * time.Millisecond)
+	writeMessage(w, http.StatusOK, "delay configured")
+}
+
+func (s *Simulator) configureToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	s.mutex.Lock()
+	s.token = body.Token
+	s.mutex.Unlock()
+
+	writeMessage(w, http.StatusOK, "token configured")
+}
+
+func (s *Simulator) configureErrorRate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ErrorRate float64 `json:"errorRate"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	s.SetErrorRate(body.ErrorRate)
+	writeMessage(w, http.StatusOK, "error rate configured")
+}
+
+func parseBound(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// sleep waits for d, reporting false when the client went away first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := sonic.ConfigDefault.NewDecoder(r.Body).Decode(v); err != nil {
+		writeMessage(w, http.StatusBadRequest, "invalid body")
+		return false
+	}
+
+	return true
+}
+
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := sonic.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
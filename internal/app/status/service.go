@@ -0,0 +1,173 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	statusKeyPrefix   = "payment_status:"
+	attemptsKeyPrefix = "payment_attempts:"
+)
+
+// transitions lists, for every state, the states a payment may be in before
+// moving to it. An empty state means the payment is not tracked yet.
+var transitions = map[models.PaymentState][]models.PaymentState{
+	models.PaymentReceived: {""},
+	models.PaymentQueued:   {models.PaymentReceived, models.PaymentFailed, models.PaymentAbandoned},
+	// A worker may pick a payment up before the handler marks it queued. A
+	// payment already processing is never picked up again, so a payment
+	// delivered twice is only sent to a processor once.
+	models.PaymentProcessing: {"", models.PaymentReceived, models.PaymentQueued, models.PaymentRetrying},
+	models.PaymentRetrying:   {models.PaymentProcessing, models.PaymentRetrying},
+	// A dead-lettered payment a processor turns out to have charged succeeded.
+	models.PaymentSucceeded: {models.PaymentProcessing, models.PaymentRetrying, models.PaymentFailed, models.PaymentAbandoned},
+	models.PaymentFailed:    {models.PaymentProcessing, models.PaymentRetrying},
+	models.PaymentAbandoned: {models.PaymentProcessing, models.PaymentRetrying},
+}
+
+// transitionScript appends the attempt (if any) and moves the payment to the
+// new state only when its current state is one of the allowed ones.
+var transitionScript = redis.NewScript(`
+local ttl = tonumber(ARGV[6])
+
+if ARGV[5] ~= '' then
+	redis.call('RPUSH', KEYS[2], ARGV[5])
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+
+local current = redis.call('HGET', KEYS[1], 'status') or ''
+for i = 7, #ARGV do
+	if ARGV[i] == current then
+		redis.call('HSET', KEYS[1], 'status', ARGV[1], 'updatedAt', ARGV[2], 'amount', ARGV[3])
+		if ARGV[4] ~= '' then
+			redis.call('HSET', KEYS[1], 'processor', ARGV[4])
+		end
+		redis.call('PEXPIRE', KEYS[1], ttl)
+		return 1
+	end
+end
+
+return 0
+`)
+
+var ErrInvalidTransition = fmt.Errorf("invalid payment status transition")
+
+type StatusService struct {
+	cache *redis.Client
+	ttl   time.Duration
+}
+
+func NewStatusService(cache *redis.Client, ttl time.Duration) *StatusService {
+	return &StatusService{
+		cache: cache,
+		ttl:   ttl,
+	}
+}
+
+// Transition moves the payment to state, recording attempt in its history
+// when given. ErrInvalidTransition is returned if the payment's current state
+// does not allow it, e.g. a late "queued" after a worker already picked it up.
+func (s *StatusService) Transition(ctx context.Context, payment *models.Payment, state models.PaymentState, attempt *models.PaymentAttempt) error {
+	var attemptPayload []byte
+	if attempt != nil {
+		payload, err := sonic.ConfigFastest.Marshal(attempt)
+		if err != nil {
+			return err
+		}
+		attemptPayload = payload
+	}
+
+	args := []any{
+		string(state),
+		time.Now().UTC().Format(time.RFC3339Nano),
+		payment.Amount.String(),
+		payment.ProcessingType,
+		attemptPayload,
+		s.ttl.Milliseconds(),
+	}
+	for _, from := range transitions[state] {
+		args = append(args, string(from))
+	}
+
+	keys := []string{statusKey(payment.CorrelationID), attemptsKey(payment.CorrelationID)}
+
+	applied, err := transitionScript.Run(ctx, s.cache, keys, args...).Int()
+	if err != nil {
+		return err
+	}
+
+	if applied == 0 {
+		return ErrInvalidTransition
+	}
+
+	return nil
+}
+
+func (s *StatusService) GetStatus(ctx context.Context, correlationID string) (*models.PaymentStatus, error) {
+	fields, err := s.cache.HGetAll(ctx, statusKey(correlationID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	status := &models.PaymentStatus{
+		CorrelationID: correlationID,
+		Status:        models.PaymentState(fields["status"]),
+		Processor:     fields["processor"],
+		Attempts:      []models.PaymentAttempt{},
+	}
+	status.Amount, _ = models.ParseMoney(fields["amount"])
+	status.UpdatedAt, _ = time.Parse(time.RFC3339Nano, fields["updatedAt"])
+
+	attempts, err := s.cache.LRange(ctx, attemptsKey(correlationID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, data := range attempts {
+		var attempt models.PaymentAttempt
+		if err := sonic.ConfigFastest.UnmarshalFromString(data, &attempt); err != nil {
+			return nil, err
+		}
+
+		status.Attempts = append(status.Attempts, attempt)
+	}
+
+	return status, nil
+}
+
+// Forget drops the status of a payment that was never accepted.
+func (s *StatusService) Forget(ctx context.Context, correlationID string) error {
+	return s.cache.Del(ctx, statusKey(correlationID), attemptsKey(correlationID)).Err()
+}
+
+func (s *StatusService) Purge(ctx context.Context) error {
+	if err := storage.DeleteByPattern(ctx, s.cache, statusKeyPrefix+"*"); err != nil {
+		return err
+	}
+
+	return storage.DeleteByPattern(ctx, s.cache, attemptsKeyPrefix+"*")
+}
+
+// Count returns how many payments have a status.
+func (s *StatusService) Count(ctx context.Context) (int64, error) {
+	return storage.CountByPattern(ctx, s.cache, statusKeyPrefix+"*")
+}
+
+func statusKey(correlationID string) string {
+	return statusKeyPrefix + correlationID
+}
+
+func attemptsKey(correlationID string) string {
+	return attemptsKeyPrefix + correlationID
+}
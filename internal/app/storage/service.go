@@ -1,74 +1,279 @@
 package storage
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
-	"math"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/redis/go-redis/v9"
 )
 
-const paymentsKey = "payments"
+const (
+	paymentsKey            = "payments"
+	unprocessedKey         = "unprocessed_payments"
+	paymentsIndexKeyPrefix = "payments_index:"
+	indexVersionKey        = "payments_index_version"
+	indexVersion           = "2"
+	migrationBatchSize     = 1000
+)
 
+// StorageService keeps every processed payment in the payments hash, keyed by
+// correlation ID, plus one sorted set per configured processor scored by
+// RequestedAt so summaries only read the requested time window. Index members
+// are "<correlationId>:<amount in cents>" so summing a window needs no extra
+// lookups and stays exact.
 type StorageService struct {
-	cache *redis.Client
+	cache      *redis.Client
+	processors []string
 }
 
-func NewStorageService(cache *redis.Client) *StorageService {
+func NewStorageService(cache *redis.Client, processors []string) *StorageService {
 	return &StorageService{
-		cache: cache,
+		cache:      cache,
+		processors: processors,
 	}
 }
 
 func (s *StorageService) SavePayment(ctx context.Context, payment *models.Payment) error {
+	defer metrics.ObserveStorage("save_payment", time.Now())
+
 	payload, err := marshalPayment(payment)
 	if err != nil {
 		return err
 	}
 
-	return s.cache.HSet(ctx, paymentsKey, payment.CorrelationID, payload).Err()
+	_, err = s.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, paymentsKey, payment.CorrelationID, payload)
+		pipe.ZAdd(ctx, paymentsIndexKey(payment.ProcessingType), indexEntry(payment))
+		return nil
+	})
+
+	return err
 }
 
-func (s *StorageService) GetPaymentsSummary(ctx context.Context, from, to *time.Time) (*models.PaymentsSummary, error) {
-	var paymentsSummary models.PaymentsSummary
+func (s *StorageService) GetPayment(ctx context.Context, correlationID string) (*models.Payment, error) {
+	defer metrics.ObserveStorage("get_payment", time.Now())
 
-	paymentsMap, err := s.cache.HGetAll(ctx, paymentsKey).Result()
+	data, err := s.cache.HGet(ctx, paymentsKey, correlationID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	for _, data := range paymentsMap {
-		var payment models.Payment
+	var payment models.Payment
+	if err := sonic.ConfigFastest.Unmarshal(data, &payment); err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+func (s *StorageService) GetPaymentsSummary(ctx context.Context, from, to *time.Time) (*models.PaymentsSummary, error) {
+	defer metrics.ObserveStorage("get_payments_summary", time.Now())
 
-		decoder := sonic.ConfigFastest.NewDecoder(bytes.NewReader([]byte(data)))
-		if err := decoder.Decode(&payment); err != nil {
+	rangeBy := &redis.ZRangeBy{
+		Min: scoreBound(from, "-inf"),
+		Max: scoreBound(to, "+inf"),
+	}
+
+	pipe := s.cache.Pipeline()
+	members := make([]*redis.StringSliceCmd, len(s.processors))
+	for i, processor := range s.processors {
+		members[i] = pipe.ZRangeByScore(ctx, paymentsIndexKey(processor), rangeBy)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	paymentsSummary := make(models.PaymentsSummary, len(s.processors))
+	for i, processor := range s.processors {
+		summary, err := summarize(members[i].Val())
+		if err != nil {
 			return nil, err
 		}
+		paymentsSummary[processor] = summary
+	}
+
+	return &paymentsSummary, nil
+}
+
+func (s *StorageService) PurgePayments(ctx context.Context) error {
+	defer metrics.ObserveStorage("purge_payments", time.Now())
 
-		if !paymentWithinTime(payment.RequestedAt, from, to) {
+	if err := s.cache.Del(ctx, paymentsKey, unprocessedKey).Err(); err != nil {
+		return err
+	}
+
+	return s.dropIndexes(ctx)
+}
+
+// CountPayments returns how many processed and unprocessed payments are
+// stored.
+func (s *StorageService) CountPayments(ctx context.Context) (int64, int64, error) {
+	var payments, unprocessed *redis.IntCmd
+	_, err := s.cache.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		payments = pipe.HLen(ctx, paymentsKey)
+		unprocessed = pipe.LLen(ctx, unprocessedKey)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return payments.Val(), unprocessed.Val(), nil
+}
+
+// SaveUnprocessed persists payments that were accepted but could not be
+// processed before shutdown.
+func (s *StorageService) SaveUnprocessed(ctx context.Context, payments ...*models.Payment) error {
+	defer metrics.ObserveStorage("save_unprocessed", time.Now())
+
+	values := make([]any, 0, len(payments))
+	for _, payment := range payments {
+		payload, err := marshalPayment(payment)
+		if err != nil {
+			return err
+		}
+		values = append(values, payload)
+	}
+
+	return s.cache.RPush(ctx, unprocessedKey, values...).Err()
+}
+
+// PopUnprocessed removes and returns up to count unprocessed payments. Each
+// payment is returned to a single caller even with several instances starting.
+func (s *StorageService) PopUnprocessed(ctx context.Context, count int) ([]*models.Payment, error) {
+	defer metrics.ObserveStorage("pop_unprocessed", time.Now())
+
+	values, err := s.cache.LPopCount(ctx, unprocessedKey, count).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payments := make([]*models.Payment, 0, len(values))
+	for _, value := range values {
+		var payment models.Payment
+		if err := sonic.ConfigFastest.UnmarshalFromString(value, &payment); err != nil {
+			log.Printf("Skipping unprocessed payment: %v\n", err)
 			continue
 		}
+		payments = append(payments, &payment)
+	}
+
+	return payments, nil
+}
+
+func (s *StorageService) dropIndexes(ctx context.Context) error {
+	var keys []string
+	for _, processor := range s.processors {
+		keys = append(keys, paymentsIndexKey(processor))
+	}
+
+	return s.cache.Del(ctx, keys...).Err()
+}
+
+// MigrateLegacyPayments (re)builds the time index from the payments hash when
+// it was written by an older version, either before the index existed or with
+// float amounts in its members. It runs once per Redis dataset and is safe to
+// run concurrently from several instances, since re-adding an index entry is a
+// no-op.
+func (s *StorageService) MigrateLegacyPayments(ctx context.Context) error {
+	version, err := s.cache.Get(ctx, indexVersionKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if version == indexVersion {
+		return nil
+	}
 
-		if payment.ProcessingType == "default" {
-			paymentsSummary.DefaultSummary.TotalRequests++
-			paymentsSummary.DefaultSummary.TotalAmount += payment.Amount
-		} else {
-			paymentsSummary.FallbackSummary.TotalRequests++
-			paymentsSummary.FallbackSummary.TotalAmount += payment.Amount
+	// Payments saved while rebuilding are indexed by SavePayment itself, so
+	// dropping the outdated index first loses nothing.
+	if version != "" {
+		if err := s.dropIndexes(ctx); err != nil {
+			return err
 		}
 	}
 
-	paymentsSummary.DefaultSummary.TotalAmount = math.Round(paymentsSummary.DefaultSummary.TotalAmount*100) / 100
-	paymentsSummary.FallbackSummary.TotalAmount = math.Round(paymentsSummary.FallbackSummary.TotalAmount*100) / 100
+	var (
+		cursor   uint64
+		migrated int
+	)
+	for {
+		fields, next, err := s.cache.HScan(ctx, paymentsKey, cursor, "*", migrationBatchSize).Result()
+		if err != nil {
+			return err
+		}
 
-	return &paymentsSummary, nil
+		pipe := s.cache.Pipeline()
+		for i := 0; i+1 < len(fields); i += 2 {
+			var payment models.Payment
+			if err := sonic.ConfigFastest.UnmarshalFromString(fields[i+1], &payment); err != nil {
+				log.Printf("Skipping legacy payment %s: %v\n", fields[i], err)
+				continue
+			}
+
+			pipe.ZAdd(ctx, paymentsIndexKey(payment.ProcessingType), indexEntry(&payment))
+			migrated++
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if migrated > 0 {
+		log.Printf("Indexed %d legacy payments\n", migrated)
+	}
+
+	return s.cache.Set(ctx, indexVersionKey, indexVersion, 0).Err()
 }
 
-func (s *StorageService) PurgePayments(ctx context.Context) error {
-	return s.cache.Del(ctx, paymentsKey).Err()
+// DeleteByPattern removes every key matching pattern, scanning in batches so
+// Redis is not blocked by a KEYS call.
+func DeleteByPattern(ctx context.Context, cache *redis.Client, pattern string) error {
+	iter := cache.Scan(ctx, 0, pattern, 1000).Iterator()
+
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return cache.Unlink(ctx, keys...).Err()
+}
+
+// CountByPattern returns how many keys match pattern, scanning in batches like
+// DeleteByPattern.
+func CountByPattern(ctx context.Context, cache *redis.Client, pattern string) (int64, error) {
+	iter := cache.Scan(ctx, 0, pattern, 1000).Iterator()
+
+	var count int64
+	for iter.Next(ctx) {
+		count++
+	}
+
+	return count, iter.Err()
 }
 
 func marshalPayment(payment *models.Payment) ([]byte, error) {
@@ -80,14 +285,39 @@ func marshalPayment(payment *models.Payment) ([]byte, error) {
 	return data, nil
 }
 
-func paymentWithinTime(requestedAt time.Time, from, to *time.Time) bool {
-	if from != nil && requestedAt.Before(*from) {
-		return false
+func paymentsIndexKey(processor string) string {
+	return paymentsIndexKeyPrefix + processor
+}
+
+func indexEntry(payment *models.Payment) redis.Z {
+	return redis.Z{
+		Score:  float64(payment.RequestedAt.UnixMicro()),
+		Member: payment.CorrelationID + ":" + strconv.FormatInt(payment.Amount.Cents(), 10),
+	}
+}
+
+func scoreBound(t *time.Time, unbounded string) string {
+	if t == nil {
+		return unbounded
 	}
 
-	if to != nil && requestedAt.After(*to) {
-		return false
+	return strconv.FormatInt(t.UnixMicro(), 10)
+}
+
+func summarize(members []string) (models.Summary, error) {
+	var summary models.Summary
+
+	for _, member := range members {
+		idx := strings.LastIndexByte(member, ':')
+
+		cents, err := strconv.ParseInt(member[idx+1:], 10, 64)
+		if err != nil {
+			return summary, err
+		}
+
+		summary.TotalRequests++
+		summary.TotalAmount += models.Money(cents)
 	}
 
-	return true
+	return summary, nil
 }
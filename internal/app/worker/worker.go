@@ -3,54 +3,233 @@ package worker
 import (
 	"context"
 	"errors"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/retry"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
 	"log"
+	"slices"
+	"time"
+)
+
+// errRedelivered is the reason a payment delivered again while processing is
+// looked up: the worker that was processing it is gone and may have charged
+// it.
+var errRedelivered = fmt.Errorf("payment redelivered while processing")
+
+const (
+	// saveAttempts and saveRetryDelay bound how long a charged payment is
+	// saved again before it is handed to the retry scheduler.
+	saveAttempts   = 4
+	saveRetryDelay = 100 * time.Millisecond
 )
 
 type Worker struct {
-	id             int
-	events         chan *models.Payment
-	retryEvents    chan *RetryEvent
-	paymentService *payment.PaymentService
-	storageService *storage.StorageService
+	id                int
+	paymentQueue      queue.Queue
+	retryScheduler    *retry.RetryScheduler
+	paymentService    *payment.PaymentService
+	storageService    *storage.StorageService
+	statusService     *status.StatusService
+	deadLetterService *deadletter.DeadLetterService
 }
 
-func NewWorker(id int, events chan *models.Payment, retryEvents chan *RetryEvent, ps *payment.PaymentService, ss *storage.StorageService) *Worker {
+func NewWorker(id int, paymentQueue queue.Queue, rs *retry.RetryScheduler, ps *payment.PaymentService, ss *storage.StorageService, sts *status.StatusService, dls *deadletter.DeadLetterService) *Worker {
 	return &Worker{
-		id:             id,
-		events:         events,
-		retryEvents:    retryEvents,
-		paymentService: ps,
-		storageService: ss,
+		id:                id,
+		paymentQueue:      paymentQueue,
+		retryScheduler:    rs,
+		paymentService:    ps,
+		storageService:    ss,
+		statusService:     sts,
+		deadLetterService: dls,
 	}
 }
 
 func (w *Worker) StartWork(ctx context.Context) {
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		case event, ok := <-w.events:
-			if !ok {
+		message, err := w.paymentQueue.Dequeue(ctx)
+		if err != nil {
+			if errors.Is(err, queue.ErrQueueClosed) || ctx.Err() != nil {
 				return
 			}
 
-			if err := w.paymentService.MakePayment(ctx, event); err != nil {
-				if errors.Is(err, payment.ErrNoAvailableProcessor) || err == payment.ErrPaymentProcessingFailed {
-					w.retryEvents <- &RetryEvent{
-						Payment:    event,
-						RetryCount: 0,
-					}
-				}
+			log.Printf("Worker %d: failed to dequeue payment: %v\n", w.id, err)
+			continue
+		}
+
+		event := message.Payment
+		if !w.startPayment(ctx, message) {
+			continue
+		}
 
+		attempt, err := w.paymentService.MakePayment(ctx, event)
+		if err != nil {
+			if w.paymentService.ShouldRetry(err) {
+				w.scheduleRetry(ctx, message, attempt, err)
 				continue
 			}
 
-			if err := w.storageService.SavePayment(ctx, event); err != nil {
-				log.Printf("Worker %d: failed to save payment %s: %v\n", w.id, event.CorrelationID, err)
-			}
+			transitionPayment(ctx, w.statusService, w.id, event, models.PaymentFailed, attempt)
+			deadLetterPayment(ctx, w.deadLetterService, w.id, event, models.PaymentFailed, err, 1, nil)
+			ackMessage(ctx, w.paymentQueue, w.id, message)
+			continue
+		}
+
+		if err := savePayment(ctx, w.storageService, w.id, event); err != nil {
+			w.scheduleLookup(ctx, message, attempt, err, []string{event.ProcessingType})
+			continue
 		}
+
+		transitionPayment(ctx, w.statusService, w.id, event, models.PaymentSucceeded, attempt)
+		ackMessage(ctx, w.paymentQueue, w.id, message)
+	}
+}
+
+// startPayment marks the payment as processing, reporting false when it must
+// not be sent. A payment already done or handed to the retry scheduler is
+// acknowledged and skipped. A payment still processing is redelivered from a
+// worker that is gone, after a restart or claimed from a dead consumer, which
+// may have charged it: it is looked up on every processor instead of being
+// sent again.
+func (w *Worker) startPayment(ctx context.Context, message *queue.Message) bool {
+	event := message.Payment
+
+	err := w.statusService.Transition(ctx, event, models.PaymentProcessing, nil)
+	if errors.Is(err, status.ErrInvalidTransition) {
+		current, err := w.statusService.GetStatus(ctx, event.CorrelationID)
+		if err != nil {
+			// Left unacknowledged, a redelivery checks it again
+			log.Printf("Worker %d: failed to get payment %s status: %v\n", w.id, event.CorrelationID, err)
+			return false
+		}
+
+		if current != nil && current.Status == models.PaymentProcessing {
+			log.Printf("Worker %d: payment %s redelivered while processing, looking it up\n", w.id, event.CorrelationID)
+			w.scheduleLookup(ctx, message, nil, errRedelivered, w.paymentService.Processors())
+			return false
+		}
+
+		log.Printf("Worker %d: payment %s is already done, skipping\n", w.id, event.CorrelationID)
+		ackMessage(ctx, w.paymentQueue, w.id, message)
+		return false
+	}
+	if err != nil {
+		log.Printf("Worker %d: failed to set payment %s status to %s: %v\n", w.id, event.CorrelationID, models.PaymentProcessing, err)
+	}
+
+	return true
+}
+
+// scheduleLookup hands a payment the processors may have charged to the retry
+// scheduler, marked as possibly charged by them, so it is looked up and saved
+// instead of being sent again. When it cannot be scheduled it is dead-lettered
+// with the same processors, to be looked up on requeue.
+func (w *Worker) scheduleLookup(ctx context.Context, message *queue.Message, attempt *models.PaymentAttempt, reason error, processors []string) {
+	event := message.Payment
+	// Still attempted when the worker is stopping
+	ctx = context.WithoutCancel(ctx)
+
+	if err := w.retryScheduler.StartLookup(ctx, event, reason, processors); err != nil {
+		log.Printf("Worker %d: failed to schedule lookup of payment %s, dead-lettering it: %v\n", w.id, event.CorrelationID, err)
+
+		if err := deadLetterPayment(ctx, w.deadLetterService, w.id, event, models.PaymentAbandoned, reason, 1, processors); err != nil {
+			// Left unacknowledged and processing, a redelivery schedules the
+			// lookup again
+			return
+		}
+
+		transitionPayment(ctx, w.statusService, w.id, event, models.PaymentAbandoned, attempt)
+		ackMessage(ctx, w.paymentQueue, w.id, message)
+		return
 	}
+
+	transitionPayment(ctx, w.statusService, w.id, event, models.PaymentRetrying, attempt)
+	ackMessage(ctx, w.paymentQueue, w.id, message)
+}
+
+// scheduleRetry hands the payment over to the retry scheduler and
+// acknowledges it, the scheduler persisting it from then on.
+func (w *Worker) scheduleRetry(ctx context.Context, message *queue.Message, attempt *models.PaymentAttempt, reason error) {
+	event := message.Payment
+
+	scheduled, err := w.retryScheduler.Start(ctx, event, reason)
+	if err != nil {
+		// Left unacknowledged, a durable queue delivers it again
+		log.Printf("Worker %d: failed to schedule retry of payment %s: %v\n", w.id, event.CorrelationID, err)
+		transitionPayment(ctx, w.statusService, w.id, event, models.PaymentRetrying, attempt)
+		return
+	}
+
+	if !scheduled {
+		metrics.PaymentRetryGiveUps.Inc()
+		transitionPayment(ctx, w.statusService, w.id, event, models.PaymentAbandoned, attempt)
+		deadLetterPayment(ctx, w.deadLetterService, w.id, event, models.PaymentAbandoned, reason, 1, nil)
+	} else {
+		transitionPayment(ctx, w.statusService, w.id, event, models.PaymentRetrying, attempt)
+	}
+
+	ackMessage(ctx, w.paymentQueue, w.id, message)
+}
+
+// savePayment persists a payment the processor charged. Only the save is
+// retried on failure, sending the payment again would charge it twice.
+func savePayment(ctx context.Context, storageService *storage.StorageService, workerID int, event *models.Payment) error {
+	delay := saveRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := storageService.SavePayment(ctx, event)
+		if err == nil || attempt == saveAttempts {
+			return err
+		}
+
+		log.Printf("Worker %d: failed to save payment %s, retrying: %v\n", workerID, event.CorrelationID, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func ackMessage(ctx context.Context, paymentQueue queue.Queue, workerID int, message *queue.Message) {
+	if err := paymentQueue.Ack(ctx, message); err != nil {
+		log.Printf("Worker %d: failed to ack payment %s: %v\n", workerID, message.Payment.CorrelationID, err)
+	}
+}
+
+func transitionPayment(ctx context.Context, statusService *status.StatusService, workerID int, event *models.Payment, state models.PaymentState, attempt *models.PaymentAttempt) {
+	err := statusService.Transition(ctx, event, state, attempt)
+	if err != nil && !errors.Is(err, status.ErrInvalidTransition) {
+		log.Printf("Worker %d: failed to set payment %s status to %s: %v\n", workerID, event.CorrelationID, state, err)
+	}
+}
+
+// deadLetterPayment records the payment as dead, with the processors that may
+// have charged it: ambiguousProcessors and the one reason is ambiguous on.
+func deadLetterPayment(ctx context.Context, deadLetterService *deadletter.DeadLetterService, workerID int, event *models.Payment, state models.PaymentState, reason error, attempts int, ambiguousProcessors []string) error {
+	if processor, ok := payment.AmbiguousProcessor(reason); ok && !slices.Contains(ambiguousProcessors, processor) {
+		ambiguousProcessors = append(slices.Clone(ambiguousProcessors), processor)
+	}
+
+	err := deadLetterService.Add(ctx, &models.DeadLetter{
+		Payment:             event,
+		Status:              state,
+		Reason:              reason.Error(),
+		Attempts:            attempts,
+		FailedAt:            time.Now().UTC(),
+		AmbiguousProcessors: ambiguousProcessors,
+	})
+	if err != nil {
+		log.Printf("Worker %d: failed to dead-letter payment %s: %v\n", workerID, event.CorrelationID, err)
+	}
+
+	return err
 }
@@ -2,40 +2,195 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/queue"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/retry"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	restoreBatchSize  = 100
+	restoreInterval   = 250 * time.Millisecond
+	drainQueueTimeout = 1500 * time.Millisecond
+	persistTimeout    = 2 * time.Second
 )
 
 type WorkerPool struct {
-	workers      []*Worker
-	retryWorkers []*RetryWorker
+	workers        []*Worker
+	retryWorkers   []*RetryWorker
+	paymentQueue   queue.Queue
+	storageService *storage.StorageService
+
+	cancel      context.CancelFunc
+	stopRetries chan struct{}
+	workersWg   sync.WaitGroup
+	retryWg     sync.WaitGroup
+	restoreWg   sync.WaitGroup
 }
 
-func NewWorkerPool(workersCount int, events chan *models.Payment, paymentService *payment.PaymentService, storageService *storage.StorageService) *WorkerPool {
-	retryEvents := make(chan *RetryEvent, 10000)
+func NewWorkerPool(workersCount int, paymentQueue queue.Queue, retryScheduler *retry.RetryScheduler, paymentService *payment.PaymentService, storageService *storage.StorageService, statusService *status.StatusService, deadLetterService *deadletter.DeadLetterService) *WorkerPool {
+	pool := &WorkerPool{
+		paymentQueue:   paymentQueue,
+		storageService: storageService,
+		stopRetries:    make(chan struct{}),
+	}
 
-	var (
-		workers      []*Worker
-		retryWorkers []*RetryWorker
-	)
 	for id := range workersCount {
-		workers = append(workers, NewWorker(id, events, retryEvents, paymentService, storageService))
-		retryWorkers = append(retryWorkers, NewRetryWorker(id, retryEvents, paymentService, storageService))
+		pool.workers = append(pool.workers, NewWorker(id, paymentQueue, retryScheduler, paymentService, storageService, statusService, deadLetterService))
+		pool.retryWorkers = append(pool.retryWorkers, NewRetryWorker(id, retryScheduler, pool.stopRetries, paymentService, storageService, statusService, deadLetterService))
 	}
 
-	return &WorkerPool{
-		workers:      workers,
-		retryWorkers: retryWorkers,
-	}
+	return pool
 }
 
 func (w *WorkerPool) StartWorkers(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+
 	for _, retryWorker := range w.retryWorkers {
-		go retryWorker.StartWork(ctx)
+		w.retryWg.Add(1)
+		go func() {
+			defer w.retryWg.Done()
+			retryWorker.StartWork(ctx)
+		}()
 	}
 
 	for _, worker := range w.workers {
-		go worker.StartWork(ctx)
+		w.workersWg.Add(1)
+		go func() {
+			defer w.workersWg.Done()
+			worker.StartWork(ctx)
+		}()
+	}
+
+	w.restoreWg.Add(1)
+	go w.restoreRoutine(ctx)
+}
+
+// restoreRoutine keeps queueing the payments spilled to Redis while the queue
+// was full, as soon as there is room again.
+func (w *WorkerPool) restoreRoutine(ctx context.Context) {
+	defer w.restoreWg.Done()
+
+	ticker := time.NewTicker(restoreInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := w.RestoreUnprocessed(ctx)
+		if errors.Is(err, queue.ErrQueueClosed) {
+			return
+		}
+		if err != nil && !errors.Is(err, queue.ErrQueueFull) && ctx.Err() == nil {
+			log.Printf("Error restoring spilled payments: %v\n", err)
+		}
+	}
+}
+
+// RestoreUnprocessed queues again the payments persisted by a previous
+// Shutdown or spilled while the queue was full. Payments that do not fit in
+// the queue are persisted back.
+func (w *WorkerPool) RestoreUnprocessed(ctx context.Context) error {
+	restored := 0
+	defer func() {
+		if restored > 0 {
+			log.Printf("Restored %d unprocessed payments\n", restored)
+		}
+	}()
+
+	for {
+		payments, err := w.storageService.PopUnprocessed(ctx, restoreBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(payments) == 0 {
+			return nil
+		}
+
+		for i, payment := range payments {
+			if err := w.paymentQueue.Enqueue(ctx, payment); err != nil {
+				if saveErr := w.storageService.SaveUnprocessed(context.WithoutCancel(ctx), payments[i:]...); saveErr != nil {
+					return errors.Join(err, saveErr)
+				}
+				return err
+			}
+			restored++
+		}
+	}
+}
+
+// Shutdown lets the workers drain the payment queue, which must already be
+// closed, and the retry workers finish their batches until ctx expires. Every
+// payment still queued after that is persisted for RestoreUnprocessed; the
+// scheduled retries already live in Redis.
+func (w *WorkerPool) Shutdown(ctx context.Context) error {
+	waitWithContext(ctx, &w.workersWg)
+
+	close(w.stopRetries)
+	waitWithContext(ctx, &w.retryWg)
+
+	w.cancel()
+	w.restoreWg.Wait()
+
+	var leftovers []*queue.Message
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainQueueTimeout)
+	defer cancelDrain()
+
+	for {
+		message, err := w.paymentQueue.Dequeue(drainCtx)
+		if err != nil {
+			break
+		}
+		leftovers = append(leftovers, message)
+	}
+
+	if len(leftovers) == 0 {
+		return nil
+	}
+
+	payments := make([]*models.Payment, 0, len(leftovers))
+	for _, message := range leftovers {
+		payments = append(payments, message.Payment)
+	}
+
+	persistCtx, cancelPersist := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancelPersist()
+
+	if err := w.storageService.SaveUnprocessed(persistCtx, payments...); err != nil {
+		return err
+	}
+
+	for _, message := range leftovers {
+		if err := w.paymentQueue.Ack(persistCtx, message); err != nil {
+			log.Printf("Failed to ack persisted payment %s: %v\n", message.Payment.CorrelationID, err)
+		}
+	}
+
+	log.Printf("Persisted %d unprocessed payments\n", len(leftovers))
+	return nil
+}
+
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
 	}
 }
@@ -2,129 +2,246 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/deadletter"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/metrics"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/payment"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/retry"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/status"
 	"francoggm/rinhabackend-2025-go-redis/internal/app/storage"
 	"francoggm/rinhabackend-2025-go-redis/internal/models"
 	"log"
-	"math"
-	"math/rand"
+	"net/http"
+	"slices"
 	"time"
 )
 
-type RetryEvent struct {
-	Payment    *models.Payment
-	RetryCount int
-}
+const (
+	retryBatchSize    = 50
+	retryPollInterval = 100 * time.Millisecond
+	// noProcessorDelay is how long a retry waits, without counting as an
+	// attempt, when no processor may receive payments.
+	noProcessorDelay = 700 * time.Millisecond
+)
 
 type RetryWorker struct {
-	id             int
-	retryEvents    chan *RetryEvent
-	paymentService *payment.PaymentService
-	storageService *storage.StorageService
+	id                int
+	retryScheduler    *retry.RetryScheduler
+	stop              <-chan struct{}
+	paymentService    *payment.PaymentService
+	storageService    *storage.StorageService
+	statusService     *status.StatusService
+	deadLetterService *deadletter.DeadLetterService
 }
 
-func NewRetryWorker(id int, retryEvents chan *RetryEvent, ps *payment.PaymentService, ss *storage.StorageService) *RetryWorker {
+func NewRetryWorker(id int, rs *retry.RetryScheduler, stop <-chan struct{}, ps *payment.PaymentService, ss *storage.StorageService, sts *status.StatusService, dls *deadletter.DeadLetterService) *RetryWorker {
 	return &RetryWorker{
-		id:             id,
-		retryEvents:    retryEvents,
-		paymentService: ps,
-		storageService: ss,
+		id:                id,
+		retryScheduler:    rs,
+		stop:              stop,
+		paymentService:    ps,
+		storageService:    ss,
+		statusService:     sts,
+		deadLetterService: dls,
 	}
 }
 
+// StartWork claims the retries that are due from the scheduler and attempts
+// them, until ctx is done or stop is closed. A batch already claimed is
+// always finished first.
 func (w *RetryWorker) StartWork(ctx context.Context) {
-	const maxBatchSize = 50
-	const batchTimeout = 200 * time.Millisecond
-
-	var batch []*RetryEvent
-
-	timer := time.NewTimer(batchTimeout)
-	if !timer.Stop() {
-		select {
-		case <-timer.C:
-		default:
-		}
-	}
-
-	flush := func() {
-		if len(batch) == 0 {
-			return
-		}
-
-		batchCopy := make([]*RetryEvent, len(batch))
-		copy(batchCopy, batch)
-
-		go w.processBatch(ctx, batchCopy)
-		batch = batch[:0]
-	}
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			flush()
 			return
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
 
-		case event, ok := <-w.retryEvents:
-			if !ok {
-				flush()
-				return
+		for {
+			entries, err := w.retryScheduler.Claim(ctx, retryBatchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Worker %d: failed to claim retries: %v\n", w.id, err)
+				}
+				break
 			}
 
-			if len(batch) == 0 {
-				timer.Reset(batchTimeout)
-			}
+			w.processBatch(ctx, entries)
 
-			batch = append(batch, event)
-			if len(batch) >= maxBatchSize {
-				if !timer.Stop() {
-					select {
-					case <-timer.C:
-					default:
-					}
-				}
-				flush()
+			if len(entries) < retryBatchSize {
+				break
 			}
-		case <-timer.C:
-			flush()
 		}
 	}
 }
 
-func (w *RetryWorker) processBatch(ctx context.Context, batch []*RetryEvent) {
-	processor := w.paymentService.HealthCheckService.AvailableProcessor(ctx)
-	if processor == "" {
-		log.Printf("Worker %d: no available processor for retry batch\n", w.id)
+// processBatch attempts the claimed entries one by one, renewing the lease of
+// each before attempting it, as the batch may take longer than the lease. An
+// entry claimed while still marked as attempting was being attempted by an
+// instance that died or lost its lease, and is looked up on every processor
+// before being sent again.
+func (w *RetryWorker) processBatch(ctx context.Context, batch []*models.RetryEntry) {
+	for _, entry := range batch {
+		if entry.Attempting {
+			for _, processor := range w.paymentService.Processors() {
+				if !slices.Contains(entry.AmbiguousProcessors, processor) {
+					entry.AmbiguousProcessors = append(entry.AmbiguousProcessors, processor)
+				}
+			}
+		}
 
-		for _, event := range batch {
-			time.AfterFunc(700*time.Millisecond, func() {
-				w.retryEvents <- event
-			})
+		renewed, err := w.retryScheduler.Renew(ctx, entry)
+		if err != nil {
+			log.Printf("Worker %d: failed to renew retry lease of payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+			continue
+		}
+		if !renewed {
+			// Claimed by another instance once the lease expired
+			continue
 		}
-		return
-	}
 
-	for _, event := range batch {
-		if err := w.paymentService.MakePayment(ctx, event.Payment); err != nil {
-			event.RetryCount++
-			const maxRetries = 6
+		if len(entry.AmbiguousProcessors) > 0 {
+			reconciled, err := w.reconcile(ctx, entry)
+			if err != nil {
+				log.Printf("Worker %d: failed to look up ambiguous payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+				w.retryFailed(ctx, entry, nil, err)
+				continue
+			}
+			if reconciled {
+				continue
+			}
+		}
 
-			if event.RetryCount >= maxRetries {
-				log.Printf("Worker %d: payment %s failed after %d retries, giving up\n", w.id, event.Payment.CorrelationID, event.RetryCount)
+		attempt, err := w.paymentService.MakePayment(ctx, entry.Payment)
+		if errors.Is(err, payment.ErrNoAvailableProcessor) {
+			if w.retryScheduler.Expired(entry) {
+				w.giveUp(ctx, entry, nil, err)
 				continue
 			}
+			if err := w.retryScheduler.Delay(ctx, entry, noProcessorDelay); err != nil {
+				log.Printf("Worker %d: failed to delay retry of payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+			}
+			continue
+		}
+
+		if err != nil && !w.paymentService.ShouldRetry(err) {
+			log.Printf("Worker %d: payment %s failed with a non-retryable error: %v\n", w.id, entry.Payment.CorrelationID, err)
+			transitionPayment(ctx, w.statusService, w.id, entry.Payment, models.PaymentFailed, attempt)
+			deadLetterPayment(ctx, w.deadLetterService, w.id, entry.Payment, models.PaymentFailed, err, entry.Attempts+1, entry.AmbiguousProcessors)
+			w.complete(ctx, entry)
+			continue
+		}
 
-			backoff := time.Duration(math.Pow(2, float64(event.RetryCount))) * 100 * time.Millisecond
-			jitter := time.Duration(rand.Intn(100)) * time.Millisecond
-			delay := backoff + jitter
+		if err != nil {
+			w.retryFailed(ctx, entry, attempt, err)
+			continue
+		}
 
-			time.AfterFunc(delay, func() {
-				w.retryEvents <- event
-			})
+		if err := savePayment(ctx, w.storageService, w.id, entry.Payment); err != nil {
+			w.scheduleLookup(ctx, entry, err)
 			continue
 		}
 
-		if err := w.storageService.SavePayment(ctx, event.Payment); err != nil {
-			log.Printf("Worker %d: failed to save payment %s after retry: %v\n", w.id, event.Payment.CorrelationID, err)
+		transitionPayment(ctx, w.statusService, w.id, entry.Payment, models.PaymentSucceeded, attempt)
+		w.complete(ctx, entry)
+	}
+}
+
+// reconcile looks the payment up on the processors whose attempts were
+// ambiguous before retrying it, so a payment they already charged is recorded
+// as succeeded on that processor instead of being charged twice.
+func (w *RetryWorker) reconcile(ctx context.Context, entry *models.RetryEntry) (bool, error) {
+	found, err := w.paymentService.LookupPayment(ctx, entry.Payment.CorrelationID, entry.AmbiguousProcessors)
+	if err != nil || found == nil {
+		return false, err
+	}
+
+	entry.Payment.ProcessingType = found.ProcessingType
+	if !found.RequestedAt.IsZero() {
+		entry.Payment.RequestedAt = found.RequestedAt
+	}
+
+	if err := w.storageService.SavePayment(ctx, entry.Payment); err != nil {
+		return false, err
+	}
+
+	log.Printf("Worker %d: payment %s was already charged by %s, not retrying\n", w.id, entry.Payment.CorrelationID, found.ProcessingType)
+	metrics.PaymentsReconciled.WithLabelValues(found.ProcessingType).Inc()
+
+	transitionPayment(ctx, w.statusService, w.id, entry.Payment, models.PaymentSucceeded, &models.PaymentAttempt{
+		Processor:   found.ProcessingType,
+		StatusCode:  http.StatusOK,
+		AttemptedAt: time.Now().UTC(),
+	})
+	w.complete(ctx, entry)
+
+	return true, nil
+}
+
+// scheduleLookup schedules the entry again, marked as possibly charged by the
+// processor that charged it but whose payment could not be saved, so it is
+// looked up and saved instead of being sent again.
+func (w *RetryWorker) scheduleLookup(ctx context.Context, entry *models.RetryEntry, reason error) {
+	// Still attempted when the worker is stopping
+	ctx = context.WithoutCancel(ctx)
+
+	processor := entry.Payment.ProcessingType
+	if !slices.Contains(entry.AmbiguousProcessors, processor) {
+		entry.AmbiguousProcessors = append(entry.AmbiguousProcessors, processor)
+	}
+	entry.LastError = reason.Error()
+
+	if err := w.retryScheduler.Delay(ctx, entry, 0); err != nil {
+		// The lease expires and the entry, without the processor marked, is
+		// claimed again
+		log.Printf("Worker %d: failed to save charged payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+	}
+}
+
+func (w *RetryWorker) retryFailed(ctx context.Context, entry *models.RetryEntry, attempt *models.PaymentAttempt, reason error) {
+	scheduled, err := w.retryScheduler.Retry(ctx, entry, reason)
+	if err != nil {
+		log.Printf("Worker %d: failed to schedule retry of payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+		return
+	}
+
+	if scheduled {
+		metrics.PaymentRetries.Inc()
+		transitionPayment(ctx, w.statusService, w.id, entry.Payment, models.PaymentRetrying, attempt)
+		return
+	}
+
+	w.giveUp(ctx, entry, attempt, reason)
+}
+
+// giveUp abandons and dead-letters an entry past its maximum attempts or age.
+func (w *RetryWorker) giveUp(ctx context.Context, entry *models.RetryEntry, attempt *models.PaymentAttempt, reason error) {
+	// A last look for a charge before giving up, the processors may have
+	// recorded it since the last attempt
+	if len(entry.AmbiguousProcessors) > 0 {
+		reconciled, err := w.reconcile(ctx, entry)
+		if err != nil {
+			log.Printf("Worker %d: failed to look up ambiguous payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+		}
+		if reconciled {
+			return
 		}
 	}
+
+	log.Printf("Worker %d: payment %s failed after %d attempts, giving up\n", w.id, entry.Payment.CorrelationID, entry.Attempts)
+	metrics.PaymentRetryGiveUps.Inc()
+	transitionPayment(ctx, w.statusService, w.id, entry.Payment, models.PaymentAbandoned, attempt)
+	deadLetterPayment(ctx, w.deadLetterService, w.id, entry.Payment, models.PaymentAbandoned, reason, entry.Attempts, entry.AmbiguousProcessors)
+	w.complete(ctx, entry)
+}
+
+func (w *RetryWorker) complete(ctx context.Context, entry *models.RetryEntry) {
+	if err := w.retryScheduler.Complete(ctx, entry.Payment.CorrelationID); err != nil {
+		log.Printf("Worker %d: failed to complete retry of payment %s: %v\n", w.id, entry.Payment.CorrelationID, err)
+	}
 }
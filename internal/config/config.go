@@ -1,8 +1,11 @@
 package config
 
 import (
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -10,6 +13,17 @@ type Config struct {
 	Workers
 	Server
 	PaymentProcessorConfig
+	Queue
+	Idempotency
+	Status
+	Routing
+	HealthCheck
+	Breaker
+	Validation
+	Admission
+	Retry
+	Admin
+	Reconciliation
 }
 
 type Cache struct {
@@ -22,17 +36,109 @@ type Workers struct {
 	PaymentBufferSize int
 }
 
+type Queue struct {
+	QueueDriver       string
+	QueueConsumer     string
+	QueueClaimMinIdle time.Duration
+}
+
+type Idempotency struct {
+	IdempotencyTTL time.Duration
+}
+
+type Status struct {
+	StatusTTL time.Duration
+}
+
+// HealthCheck sets how often the leader checks the processors health. The
+// leader lock is held for three intervals.
+type HealthCheck struct {
+	HealthCheckInterval time.Duration
+}
+
+type Routing struct {
+	RoutingPolicy                   string
+	RoutingPrimaryMaxResponseTime   time.Duration
+	RoutingSecondaryMaxResponseTime time.Duration
+	RoutingLatencyBudget            time.Duration
+	RoutingMinSuccessRate           float64
+}
+
+type Breaker struct {
+	BreakerFailureThreshold int
+	BreakerFailureWindow    time.Duration
+	BreakerOpenTimeout      time.Duration
+	BreakerHalfOpenProbes   int
+	BreakerSyncInterval     time.Duration
+}
+
 type Server struct {
-	Port string
+	Port            string
+	ShutdownTimeout time.Duration
+}
+
+type Validation struct {
+	PaymentMaxBodyBytes      int
+	PaymentMaxAmount         models.Money
+	PaymentBatchMaxItems     int
+	PaymentBatchMaxBodyBytes int
+}
+
+type Admission struct {
+	AdmissionWait          time.Duration
+	AdmissionSpill         bool
+	AdmissionRetryAfterMax time.Duration
+}
+
+type Retry struct {
+	RetryBackoff     string
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+	RetryMaxAttempts int
+	RetryMaxAge      time.Duration
+	RetryLease       time.Duration
+	// RetryStatusCodes are the processor responses retried as failures that
+	// certainly did not charge the payment.
+	RetryStatusCodes []int
+	// RetryAmbiguous is what happens to payments that failed after reaching
+	// the processor, e.g. on timeouts: "retry" or "deadletter".
+	RetryAmbiguous string
+}
+
+// Reconciliation compares the stored summary with the processors' own every
+// ReconcileInterval, over the ReconcileWindow ending ReconcileLag ago so
+// payments in flight are not reported. A zero interval disables the job.
+type Reconciliation struct {
+	ReconcileInterval time.Duration
+	ReconcileWindow   time.Duration
+	ReconcileLag      time.Duration
+}
+
+// Admin authenticates the /admin routes with either a bearer token or an
+// HMAC signature. With neither configured the admin routes are disabled.
+type Admin struct {
+	AdminToken      string
+	AdminHMACSecret string
+	AdminHMACSkew   time.Duration
 }
 
 type PaymentProcessorConfig struct {
-	DefaultURL  string
-	FallbackURL string
+	Processors []ProcessorConfig
+}
+
+type ProcessorConfig struct {
+	Name     string
+	URL      string
+	Fee      float64
+	Priority int
+	Timeout  time.Duration
+	// AdminToken and AdminTimeout are used for the processor admin API.
+	AdminToken   string
+	AdminTimeout time.Duration
 }
 
 func NewConfig() *Config {
-	return &Config{
+	cfg := &Config{
 		Cache: Cache{
 			Host: getEnvString("CACHE_HOST", "localhost"),
 			Port: getEnvString("CACHE_PORT", "6373"),
@@ -42,13 +148,96 @@ func NewConfig() *Config {
 			PaymentBufferSize: getEnvInt("PAYMENT_WORKERS_EVENTS_BUFFER_SIZE", 100),
 		},
 		Server: Server{
-			Port: getEnvString("SERVER_PORT", "8080"),
+			Port:            getEnvString("SERVER_PORT", "8080"),
+			ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 8*time.Second),
 		},
 		PaymentProcessorConfig: PaymentProcessorConfig{
-			DefaultURL:  getEnvString("PAYMENT_DEFAULT_URL", "http://localhost:8081"),
-			FallbackURL: getEnvString("PAYMENT_FALLBACK_URL", "http://localhost:8082"),
+			Processors: getEnvProcessors("PAYMENT_PROCESSORS", []ProcessorConfig{
+				{
+					Name:     "default",
+					URL:      getEnvString("PAYMENT_DEFAULT_URL", "http://localhost:8081"),
+					Fee:      0.05,
+					Priority: 1,
+					Timeout:  2 * time.Second,
+				},
+				{
+					Name:     "fallback",
+					URL:      getEnvString("PAYMENT_FALLBACK_URL", "http://localhost:8082"),
+					Fee:      0.15,
+					Priority: 2,
+					Timeout:  2 * time.Second,
+				},
+			}),
+		},
+		Queue: Queue{
+			QueueDriver:       getEnvString("QUEUE_DRIVER", "redis"),
+			QueueConsumer:     getEnvString("QUEUE_CONSUMER", hostname()),
+			QueueClaimMinIdle: getEnvDuration("QUEUE_CLAIM_MIN_IDLE", 30*time.Second),
+		},
+		Idempotency: Idempotency{
+			IdempotencyTTL: getEnvDuration("IDEMPOTENCY_TTL", time.Hour),
 		},
+		Status: Status{
+			StatusTTL: getEnvDuration("PAYMENT_STATUS_TTL", time.Hour),
+		},
+		HealthCheck: HealthCheck{
+			HealthCheckInterval: getEnvDuration("HEALTH_CHECK_INTERVAL", 5*time.Second),
+		},
+		Routing: Routing{
+			RoutingPolicy:                   getEnvString("ROUTING_POLICY", "profit"),
+			RoutingPrimaryMaxResponseTime:   getEnvDuration("ROUTING_PRIMARY_MAX_RESPONSE_TIME", 300*time.Millisecond),
+			RoutingSecondaryMaxResponseTime: getEnvDuration("ROUTING_SECONDARY_MAX_RESPONSE_TIME", 200*time.Millisecond),
+			RoutingLatencyBudget:            getEnvDuration("ROUTING_LATENCY_BUDGET", time.Second),
+			RoutingMinSuccessRate:           getEnvFloat("ROUTING_MIN_SUCCESS_RATE", 0.5),
+		},
+		Breaker: Breaker{
+			BreakerFailureThreshold: getEnvInt("BREAKER_FAILURE_THRESHOLD", 10),
+			BreakerFailureWindow:    getEnvDuration("BREAKER_FAILURE_WINDOW", 2*time.Second),
+			BreakerOpenTimeout:      getEnvDuration("BREAKER_OPEN_TIMEOUT", 2*time.Second),
+			BreakerHalfOpenProbes:   getEnvInt("BREAKER_HALF_OPEN_PROBES", 3),
+			BreakerSyncInterval:     getEnvDuration("BREAKER_SYNC_INTERVAL", 200*time.Millisecond),
+		},
+		Validation: Validation{
+			PaymentMaxBodyBytes:      getEnvInt("PAYMENT_MAX_BODY_BYTES", 1024),
+			PaymentMaxAmount:         getEnvMoney("PAYMENT_MAX_AMOUNT", 1_000_000_00),
+			PaymentBatchMaxItems:     getEnvInt("PAYMENT_BATCH_MAX_ITEMS", 1000),
+			PaymentBatchMaxBodyBytes: getEnvInt("PAYMENT_BATCH_MAX_BODY_BYTES", 1<<20),
+		},
+		Admission: Admission{
+			AdmissionWait:          getEnvDuration("ADMISSION_WAIT", 20*time.Millisecond),
+			AdmissionSpill:         getEnvBool("ADMISSION_SPILL", true),
+			AdmissionRetryAfterMax: getEnvDuration("ADMISSION_RETRY_AFTER_MAX", 30*time.Second),
+		},
+		Retry: Retry{
+			RetryBackoff:     getEnvString("RETRY_BACKOFF", "exponential"),
+			RetryBaseDelay:   getEnvDuration("RETRY_BASE_DELAY", 100*time.Millisecond),
+			RetryMaxDelay:    getEnvDuration("RETRY_MAX_DELAY", 10*time.Second),
+			RetryMaxAttempts: getEnvInt("RETRY_MAX_ATTEMPTS", 7),
+			RetryMaxAge:      getEnvDuration("RETRY_MAX_AGE", 2*time.Minute),
+			RetryLease:       getEnvDuration("RETRY_LEASE", 10*time.Second),
+			RetryStatusCodes: getEnvInts("RETRY_STATUS_CODES", []int{408, 429, 500, 502, 503}),
+			RetryAmbiguous:   getEnvString("RETRY_AMBIGUOUS", "retry"),
+		},
+		Admin: Admin{
+			AdminToken:      getEnvString("ADMIN_TOKEN", ""),
+			AdminHMACSecret: getEnvString("ADMIN_HMAC_SECRET", ""),
+			AdminHMACSkew:   getEnvDuration("ADMIN_HMAC_SKEW", 5*time.Minute),
+		},
+		Reconciliation: Reconciliation{
+			ReconcileInterval: getEnvDuration("RECONCILE_INTERVAL", time.Minute),
+			ReconcileWindow:   getEnvDuration("RECONCILE_WINDOW", 5*time.Minute),
+			ReconcileLag:      getEnvDuration("RECONCILE_LAG", 10*time.Second),
+		},
+	}
+
+	processorToken := getEnvString("PAYMENT_PROCESSOR_TOKEN", "123")
+	processorAdminTimeout := getEnvDuration("PAYMENT_PROCESSOR_ADMIN_TIMEOUT", 5*time.Second)
+	for i := range cfg.Processors {
+		cfg.Processors[i].AdminToken = processorToken
+		cfg.Processors[i].AdminTimeout = processorAdminTimeout
 	}
+
+	return cfg
 }
 
 func getEnvString(key string, defaultValue string) string {
@@ -73,3 +262,133 @@ func getEnvInt(key string, defaultValue int) int {
 
 	return intValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return floatValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return duration
+}
+
+func getEnvInts(key string, defaultValue []int) []int {
+	value, exists := os.LookupEnv(key)
+	if !exists || strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+
+	var ints []int
+	for _, field := range strings.Split(value, ",") {
+		intValue, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return defaultValue
+		}
+		ints = append(ints, intValue)
+	}
+
+	return ints
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return boolValue
+}
+
+func getEnvMoney(key string, defaultValue models.Money) models.Money {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	amount, err := models.ParseMoney(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return amount
+}
+
+// getEnvProcessors parses a comma separated list of processors, each written
+// as name|url|fee|priority|timeout, e.g.
+// "default|http://default:8080|0.05|1|2s,fallback|http://fallback:8080|0.15|2|2s".
+// Fee, priority and timeout are optional. The default list is returned when
+// the variable is unset or any processor lacks a name or URL.
+func getEnvProcessors(key string, defaultValue []ProcessorConfig) []ProcessorConfig {
+	value, exists := os.LookupEnv(key)
+	if !exists || strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+
+	var processors []ProcessorConfig
+	for i, entry := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), "|")
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return defaultValue
+		}
+
+		processor := ProcessorConfig{
+			Name:     fields[0],
+			URL:      fields[1],
+			Priority: i + 1,
+			Timeout:  2 * time.Second,
+		}
+
+		if len(fields) > 2 {
+			if fee, err := strconv.ParseFloat(fields[2], 64); err == nil {
+				processor.Fee = fee
+			}
+		}
+		if len(fields) > 3 {
+			if priority, err := strconv.Atoi(fields[3]); err == nil {
+				processor.Priority = priority
+			}
+		}
+		if len(fields) > 4 {
+			if timeout, err := time.ParseDuration(fields[4]); err == nil {
+				processor.Timeout = timeout
+			}
+		}
+
+		processors = append(processors, processor)
+	}
+
+	return processors
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "server"
+	}
+
+	return name
+}
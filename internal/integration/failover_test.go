@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"net/http"
+	"testing"
+)
+
+func TestFailoverToFallbackProcessor(t *testing.T) {
+	c := newCluster(t)
+	inst := c.start()
+	inst.waitRanked("default")
+
+	inst.pay(20)
+	summary := inst.waitProcessed(20)
+	if got := summary["default"].TotalRequests; got != 20 {
+		t.Fatalf("default processed %d payments while healthy, want 20", got)
+	}
+
+	c.processors["default"].SetFailure(true)
+	inst.waitRanked("fallback")
+
+	inst.pay(30)
+	summary = inst.waitProcessed(50)
+
+	if got := summary["default"].TotalRequests; got != 20 {
+		t.Errorf("default processed %d payments, want the 20 sent before its outage", got)
+	}
+	if got := summary["fallback"].TotalRequests; got != 30 {
+		t.Errorf("fallback processed %d payments, want 30", got)
+	}
+	c.assertMatchesProcessors(summary)
+
+	c.processors["default"].SetFailure(false)
+	inst.waitRanked("default")
+
+	inst.pay(10)
+	summary = inst.waitProcessed(60)
+	if got := summary["default"].TotalRequests; got != 30 {
+		t.Errorf("default processed %d payments after recovering, want 30", got)
+	}
+	c.assertMatchesProcessors(summary)
+
+	var report models.ReconciliationReport
+	if status := inst.admin(http.MethodPost, "/admin/reconciliation?from=2000-01-01T00:00:00Z&to=2100-01-01T00:00:00Z", true, &report); status != http.StatusOK {
+		t.Fatalf("reconciliation answered %d", status)
+	}
+	if report.HasDiscrepancies {
+		t.Errorf("reconciliation found discrepancies: %+v", report.Processors)
+	}
+}
@@ -0,0 +1,353 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/bootstrap"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/simulator"
+	"francoggm/rinhabackend-2025-go-redis/internal/config"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	adminToken    = "integration-admin"
+	paymentAmount = models.Money(1990)
+	// clockStep is how often, and by how much, the Redis stand-in's clock is
+	// moved forward, since miniredis only expires keys when told time passed.
+	clockStep = 50 * time.Millisecond
+)
+
+// processorSim is a simulated processor served over HTTP, counting its health
+// checks.
+type processorSim struct {
+	*simulator.Simulator
+	server       *httptest.Server
+	healthChecks atomic.Int64
+}
+
+// cluster is a Redis stand-in, the simulated processors "default" and
+// "fallback" and the instances started on them.
+type cluster struct {
+	t          *testing.T
+	redis      *miniredis.Miniredis
+	processors map[string]*processorSim
+	env        map[string]string
+}
+
+type clusterOption func(*cluster)
+
+// withProcessor replaces the simulator config of a processor.
+func withProcessor(name string, cfg simulator.Config) clusterOption {
+	return func(c *cluster) {
+		c.processors[name] = newProcessorSim(c.t, cfg)
+	}
+}
+
+// withEnv overrides the environment the instances are configured from.
+func withEnv(key, value string) clusterOption {
+	return func(c *cluster) {
+		c.env[key] = value
+	}
+}
+
+func newCluster(t *testing.T, opts ...clusterOption) *cluster {
+	t.Helper()
+
+	c := &cluster{
+		t:     t,
+		redis: miniredis.RunT(t),
+		processors: map[string]*processorSim{
+			"default":  newProcessorSim(t, simulatorConfig(0.05)),
+			"fallback": newProcessorSim(t, simulatorConfig(0.15)),
+		},
+		env: map[string]string{
+			"HEALTH_CHECK_INTERVAL":     "100ms",
+			"QUEUE_DRIVER":              "redis",
+			"PAYMENT_WORKERS_COUNT":     "4",
+			"RETRY_BASE_DELAY":          "20ms",
+			"RETRY_MAX_DELAY":           "200ms",
+			"RETRY_LEASE":               "2s",
+			"RETRY_MAX_ATTEMPTS":        "50",
+			"BREAKER_OPEN_TIMEOUT":      "200ms",
+			"BREAKER_FAILURE_WINDOW":    "500ms",
+			"ADMIN_TOKEN":               adminToken,
+			"RECONCILE_INTERVAL":        "0",
+			"SHUTDOWN_TIMEOUT":          "2s",
+			"PAYMENT_PROCESSOR_TOKEN":   "123",
+			"ADMISSION_RETRY_AFTER_MAX": "1s",
+		},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		ticker := time.NewTicker(clockStep)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.redis.FastForward(clockStep)
+			}
+		}
+	}()
+
+	return c
+}
+
+func simulatorConfig(fee float64) simulator.Config {
+	return simulator.Config{
+		Fee:     fee,
+		Token:   "123",
+		Latency: simulator.Latency{Distribution: "uniform", Min: time.Millisecond, Max: 3 * time.Millisecond},
+	}
+}
+
+func newProcessorSim(t *testing.T, cfg simulator.Config) *processorSim {
+	sim := &processorSim{Simulator: simulator.NewSimulator(cfg)}
+	sim.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/payments/service-health" {
+			sim.healthChecks.Add(1)
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	t.Cleanup(sim.server.Close)
+
+	return sim
+}
+
+// instance is one server process: its HTTP server, worker pool and
+// background services, sharing Redis and the processors with the others.
+type instance struct {
+	t      *testing.T
+	cfg    *config.Config
+	url    string
+	server *httptest.Server
+
+	cache *redis.Client
+	app   *bootstrap.App
+
+	stopOnce sync.Once
+}
+
+// start boots an instance with the wiring cmd/server uses, serving it on a
+// test server instead of the configured port.
+func (c *cluster) start() *instance {
+	t := c.t
+	t.Helper()
+
+	t.Setenv("CACHE_HOST", c.redis.Host())
+	t.Setenv("CACHE_PORT", c.redis.Port())
+	t.Setenv("PAYMENT_PROCESSORS", fmt.Sprintf("default|%s|0.05|1|300ms,fallback|%s|0.15|2|300ms",
+		c.processors["default"].server.URL, c.processors["fallback"].server.URL))
+	for key, value := range c.env {
+		t.Setenv(key, value)
+	}
+
+	cfg := config.NewConfig()
+	cfg.QueueConsumer = "consumer-" + uuid.NewString()
+
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: c.redis.Addr()})
+
+	app, err := bootstrap.NewApp(ctx, cfg, rdb)
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	if err := app.Start(ctx); err != nil {
+		t.Fatalf("failed to start instance: %v", err)
+	}
+
+	inst := &instance{
+		t:      t,
+		cfg:    cfg,
+		server: httptest.NewServer(app.Server.Handler()),
+		cache:  rdb,
+		app:    app,
+	}
+	inst.url = inst.server.URL
+	t.Cleanup(inst.stop)
+
+	return inst
+}
+
+// stop shuts the instance down the way cmd/server does on SIGTERM. The
+// test server stands in for the HTTP server, it is closed first.
+func (i *instance) stop() {
+	i.stopOnce.Do(func() {
+		i.server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), i.cfg.ShutdownTimeout)
+		defer cancel()
+
+		if err := i.app.Shutdown(ctx); err != nil {
+			i.t.Errorf("failed to shut down instance: %v", err)
+		}
+
+		i.cache.Close()
+	})
+}
+
+// waitRanked waits until the instance routes payments to first.
+func (i *instance) waitRanked(first string) {
+	i.t.Helper()
+
+	waitFor(i.t, 5*time.Second, fmt.Sprintf("%s to be ranked first", first), func() bool {
+		ranking := i.app.HealthCheckService.RankedProcessors(context.Background())
+		return len(ranking) > 0 && ranking[0] == first
+	})
+}
+
+// pay submits count payments and returns their correlation IDs.
+func (i *instance) pay(count int) []string {
+	i.t.Helper()
+
+	ids := make([]string, count)
+	for n := range ids {
+		ids[n] = uuid.NewString()
+
+		if status := i.submit(ids[n]); status < 200 || status >= 300 {
+			i.t.Fatalf("payment %s rejected with status %d", ids[n], status)
+		}
+	}
+
+	return ids
+}
+
+// submit posts a payment and returns the response status code.
+func (i *instance) submit(correlationID string) int {
+	i.t.Helper()
+
+	body := fmt.Sprintf(`{"correlationId":%q,"amount":%s}`, correlationID, paymentAmount)
+	resp, err := http.Post(i.url+"/payments", "application/json", strings.NewReader(body))
+	if err != nil {
+		i.t.Fatalf("failed to submit payment: %v", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+// summary returns /payments-summary within from and to, both optional.
+func (i *instance) summary(from, to *time.Time) models.PaymentsSummary {
+	i.t.Helper()
+
+	query := url.Values{}
+	if from != nil {
+		query.Set("from", from.Format(time.RFC3339Nano))
+	}
+	if to != nil {
+		query.Set("to", to.Format(time.RFC3339Nano))
+	}
+
+	var summary models.PaymentsSummary
+	i.getJSON("/payments-summary?"+query.Encode(), &summary)
+
+	return summary
+}
+
+// waitProcessed waits until the summary counts total payments.
+func (i *instance) waitProcessed(total int) models.PaymentsSummary {
+	i.t.Helper()
+
+	var summary models.PaymentsSummary
+	waitFor(i.t, 10*time.Second, fmt.Sprintf("%d payments to be processed", total), func() bool {
+		summary = i.summary(nil, nil)
+		return summary["default"].TotalRequests+summary["fallback"].TotalRequests >= total
+	})
+
+	return summary
+}
+
+func (i *instance) getJSON(path string, v any) int {
+	i.t.Helper()
+
+	resp, err := http.Get(i.url + path)
+	if err != nil {
+		i.t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			i.t.Fatalf("failed to decode GET %s response: %v", path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+// admin sends an admin request with the bearer token, when authenticated.
+func (i *instance) admin(method, path string, authenticated bool, v any) int {
+	i.t.Helper()
+
+	req, err := http.NewRequest(method, i.url+path, bytes.NewReader(nil))
+	if err != nil {
+		i.t.Fatalf("failed to build request: %v", err)
+	}
+	if authenticated {
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		i.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			i.t.Fatalf("failed to decode %s %s response: %v", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// assertMatchesProcessors checks that the stored summary of every processor
+// matches what the processor itself recorded.
+func (c *cluster) assertMatchesProcessors(summary models.PaymentsSummary) {
+	c.t.Helper()
+
+	for name, sim := range c.processors {
+		processed := sim.Summary(nil, nil)
+		stored := summary[name]
+
+		if stored.TotalRequests != processed.TotalRequests || stored.TotalAmount != processed.TotalAmount {
+			c.t.Errorf("%s: stored %d payments (%s), processor recorded %d (%s)", name,
+				stored.TotalRequests, stored.TotalAmount, processed.TotalRequests, processed.TotalAmount)
+		}
+	}
+}
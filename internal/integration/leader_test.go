@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const leaderLockKey = "processor_health_leader_lock"
+
+func TestSingleHealthCheckLeader(t *testing.T) {
+	c := newCluster(t)
+	first, second := c.start(), c.start()
+	first.waitRanked("default")
+	second.waitRanked("default")
+
+	c.assertSingleLeader()
+	token := c.leaderToken()
+
+	// Both instances follow the snapshots of the single leader
+	c.processors["default"].SetFailure(true)
+	first.waitRanked("fallback")
+	second.waitRanked("fallback")
+
+	c.processors["default"].SetFailure(false)
+	first.waitRanked("default")
+	second.waitRanked("default")
+
+	// Payments submitted to either instance are processed once
+	first.pay(10)
+	second.pay(10)
+	c.assertMatchesProcessors(first.waitProcessed(20))
+
+	// Whichever instance stops, health checks go on with a single leader and
+	// a leader change issues a newer fencing token
+	first.stop()
+	waitFor(t, 2*time.Second, "a leader after the first instance stopped", func() bool {
+		return c.leaderToken() != 0
+	})
+	if newToken := c.leaderToken(); newToken < token {
+		t.Errorf("leader token went from %d back to %d", token, newToken)
+	}
+
+	c.assertSingleLeader()
+
+	second.pay(5)
+	c.assertMatchesProcessors(second.waitProcessed(25))
+}
+
+// assertSingleLeader checks that the processors are health checked at the
+// rate of a single leader: about 10 checks a second with the 100ms interval,
+// where two leaders would make about 20.
+func (c *cluster) assertSingleLeader() {
+	c.t.Helper()
+
+	sim := c.processors["default"]
+	before := sim.healthChecks.Load()
+	time.Sleep(time.Second)
+	checks := sim.healthChecks.Load() - before
+
+	if checks < 5 || checks > 14 {
+		c.t.Errorf("processor was health checked %d times in a second, want about 10 from a single leader", checks)
+	}
+}
+
+// leaderToken returns the fencing token of the current leader, 0 when there
+// is none.
+func (c *cluster) leaderToken() int64 {
+	value, err := c.redis.Get(leaderLockKey)
+	if err != nil {
+		return 0
+	}
+
+	_, token, _ := strings.Cut(value, "|")
+	parsed, _ := strconv.ParseInt(token, 10, 64)
+	return parsed
+}
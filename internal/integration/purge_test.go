@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"context"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPurge(t *testing.T) {
+	c := newCluster(t)
+	inst := c.start()
+	inst.waitRanked("default")
+
+	ids := inst.pay(8)
+	inst.waitProcessed(8)
+
+	for _, path := range []string{"/admin/purge-payments", "/purge-payments"} {
+		if status := inst.admin(http.MethodPost, path, false, nil); status != http.StatusUnauthorized {
+			t.Errorf("unauthenticated POST %s answered %d, want %d", path, status, http.StatusUnauthorized)
+		}
+	}
+
+	var report models.PurgeReport
+	if status := inst.admin(http.MethodPost, "/admin/purge-payments?dryRun=true", true, &report); status != http.StatusOK {
+		t.Fatalf("dry run purge answered %d", status)
+	}
+	if !report.DryRun || report.Payments != 8 || report.PaymentStatuses != 8 || report.IdempotencyKeys != 8 {
+		t.Errorf("dry run reported %+v, want 8 payments, statuses and idempotency keys", report)
+	}
+
+	summary := inst.summary(nil, nil)
+	if summary["default"].TotalRequests != 8 || c.processors["default"].Summary(nil, nil).TotalRequests != 8 {
+		t.Fatalf("dry run deleted payments")
+	}
+
+	report = models.PurgeReport{}
+	if status := inst.admin(http.MethodPost, "/admin/purge-payments", true, &report); status != http.StatusOK {
+		t.Fatalf("purge answered %d", status)
+	}
+	if report.DryRun || report.Payments != 8 {
+		t.Errorf("purge reported %+v, want 8 payments deleted", report)
+	}
+
+	summary = inst.summary(nil, nil)
+	for name, sim := range c.processors {
+		if summary[name].TotalRequests != 0 || sim.Summary(nil, nil).TotalRequests != 0 {
+			t.Errorf("%s still has payments after the purge", name)
+		}
+	}
+
+	if status := inst.getJSON("/payments/"+ids[0], nil); status != http.StatusNotFound {
+		t.Errorf("purged payment status answered %d, want %d", status, http.StatusNotFound)
+	}
+
+	// A purged correlation ID is a new payment again
+	if status := inst.submit(ids[0]); status < 200 || status >= 300 {
+		t.Fatalf("resubmitting a purged payment answered %d", status)
+	}
+	inst.waitProcessed(1)
+	c.assertMatchesProcessors(inst.summary(nil, nil))
+}
+
+func TestPurgeQueuedPayments(t *testing.T) {
+	// Without workers payments stay in the stream, buffered by the instance
+	c := newCluster(t, withEnv("PAYMENT_WORKERS_COUNT", "0"))
+	inst := c.start()
+
+	inst.pay(5)
+	waitFor(t, 5*time.Second, "payments pending in the consumer group", func() bool {
+		groups, err := inst.cache.XInfoGroups(context.Background(), "payments_stream").Result()
+		return err == nil && len(groups) == 1 && groups[0].Pending == 5
+	})
+
+	var report models.PurgeReport
+	if status := inst.admin(http.MethodPost, "/admin/purge-payments", true, &report); status != http.StatusOK {
+		t.Fatalf("purge answered %d", status)
+	}
+	if report.QueuedPayments != 5 {
+		t.Errorf("purge reported %d queued payments, want 5", report.QueuedPayments)
+	}
+
+	groups, err := inst.cache.XInfoGroups(context.Background(), "payments_stream").Result()
+	if err != nil || len(groups) != 1 {
+		t.Fatalf("consumer group missing after the purge: %v", err)
+	}
+	if length, _ := inst.cache.XLen(context.Background(), "payments_stream").Result(); length != 0 || groups[0].Pending != 0 {
+		t.Errorf("stream has %d payments, %d pending, after the purge", length, groups[0].Pending)
+	}
+}
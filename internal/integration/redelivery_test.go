@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRedeliveredProcessingPaymentIsLookedUp(t *testing.T) {
+	c := newCluster(t)
+
+	// A worker moved the payment to processing and charged it on default,
+	// then its instance died before saving it or acknowledging the message
+	id := uuid.NewString()
+	payment := &models.Payment{CorrelationID: id, Amount: paymentAmount}
+	c.charge("default", payment)
+
+	c.redis.HSet("payment_status:"+id, "status", string(models.PaymentProcessing), "amount", paymentAmount.String())
+	if _, err := c.redis.XAdd("payments_stream", "*", []string{"payment", c.encode(payment)}); err != nil {
+		t.Fatalf("failed to add payment to the stream: %v", err)
+	}
+
+	inst := c.start()
+
+	summary := inst.waitProcessed(1)
+	if got := summary["default"].TotalRequests; got != 1 {
+		t.Errorf("default has %d payments, want 1", got)
+	}
+	c.assertMatchesProcessors(summary)
+
+	var status models.PaymentStatus
+	if inst.getJSON("/payments/"+id, &status); status.Status != models.PaymentSucceeded {
+		t.Errorf("payment is %q, want %q", status.Status, models.PaymentSucceeded)
+	}
+}
+
+// charge sends the payment straight to a processor, as an instance that died
+// right after would have.
+func (c *cluster) charge(processor string, payment *models.Payment) {
+	c.t.Helper()
+
+	body := fmt.Sprintf(`{"correlationId":%q,"amount":%s,"requestedAt":%q}`,
+		payment.CorrelationID, payment.Amount, time.Now().UTC().Format(time.RFC3339Nano))
+
+	resp, err := http.Post(c.processors[processor].server.URL+"/payments", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		c.t.Fatalf("failed to charge payment: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.t.Fatalf("%s answered %d to the payment", processor, resp.StatusCode)
+	}
+}
@@ -0,0 +1,248 @@
+package integration
+
+import (
+	"context"
+	"francoggm/rinhabackend-2025-go-redis/internal/app/simulator"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRetriesUntilProcessorsRecover(t *testing.T) {
+	c := newCluster(t)
+	inst := c.start()
+	inst.waitRanked("default")
+
+	for _, sim := range c.processors {
+		sim.SetErrorRate(1)
+	}
+
+	ids := inst.pay(10)
+
+	waitFor(t, 5*time.Second, "every payment to be scheduled for retry", func() bool {
+		var retries models.RetryList
+		inst.admin(http.MethodGet, "/admin/retries", true, &retries)
+		return retries.Total == 10
+	})
+
+	var status models.PaymentStatus
+	if code := inst.getJSON("/payments/"+ids[0], &status); code != http.StatusOK || status.Status != models.PaymentRetrying {
+		t.Errorf("payment status is %d %q while processors fail, want 200 %q", code, status.Status, models.PaymentRetrying)
+	}
+
+	for _, sim := range c.processors {
+		sim.SetErrorRate(0)
+	}
+
+	summary := inst.waitProcessed(10)
+	if total := summary["default"].TotalRequests + summary["fallback"].TotalRequests; total != 10 {
+		t.Errorf("processed %d payments, want 10", total)
+	}
+	c.assertMatchesProcessors(summary)
+
+	waitFor(t, 5*time.Second, "the retries to be completed", func() bool {
+		var retries models.RetryList
+		inst.admin(http.MethodGet, "/admin/retries", true, &retries)
+		return retries.Total == 0
+	})
+
+	for _, id := range ids {
+		if inst.getJSON("/payments/"+id, &status); status.Status != models.PaymentSucceeded {
+			t.Errorf("payment %s is %q, want %q", id, status.Status, models.PaymentSucceeded)
+		}
+	}
+}
+
+func TestAmbiguousPaymentsAreNotChargedTwice(t *testing.T) {
+	// The processor records every payment but answers after the 300ms
+	// processor timeout, so the service cannot tell whether it charged them
+	ambiguous := simulatorConfig(0.05)
+	ambiguous.AmbiguousRate = 1
+	ambiguous.AmbiguousDelay = time.Second
+
+	c := newCluster(t, withProcessor("default", ambiguous))
+	inst := c.start()
+	inst.waitRanked("default")
+
+	inst.pay(5)
+	summary := inst.waitProcessed(5)
+
+	if got := summary["default"].TotalRequests; got != 5 {
+		t.Errorf("default has %d payments, want 5", got)
+	}
+	if got := summary["fallback"].TotalRequests; got != 0 {
+		t.Errorf("fallback charged %d payments already charged by default", got)
+	}
+	c.assertMatchesProcessors(summary)
+}
+
+func TestRetryLeaseShorterThanBatch(t *testing.T) {
+	// Every retry comes due at once and a single worker claims them all, but
+	// attempting them one after the other takes far longer than the lease
+	slow := func(fee float64) simulator.Config {
+		cfg := simulatorConfig(fee)
+		cfg.Latency = simulator.Latency{Distribution: "fixed", Min: 20 * time.Millisecond}
+		return cfg
+	}
+
+	c := newCluster(t,
+		withProcessor("default", slow(0.05)),
+		withProcessor("fallback", slow(0.15)),
+		withEnv("RETRY_LEASE", "100ms"),
+		withEnv("RETRY_BACKOFF", "fixed"),
+		withEnv("RETRY_BASE_DELAY", "1s"),
+		withEnv("BREAKER_FAILURE_THRESHOLD", "1000"),
+	)
+	inst := c.start()
+	inst.waitRanked("default")
+
+	for _, sim := range c.processors {
+		sim.SetErrorRate(1)
+	}
+
+	ids := inst.pay(30)
+
+	waitFor(t, 5*time.Second, "every payment to be scheduled for retry", func() bool {
+		var retries models.RetryList
+		inst.admin(http.MethodGet, "/admin/retries", true, &retries)
+		return retries.Total == 30
+	})
+
+	for _, sim := range c.processors {
+		sim.SetErrorRate(0)
+	}
+
+	summary := inst.waitProcessed(30)
+	c.assertMatchesProcessors(summary)
+
+	// A payment sent again after its lease expired is refused by the
+	// processor as already charged and dead-lettered
+	waitFor(t, 5*time.Second, "the retries to be completed", func() bool {
+		var retries models.RetryList
+		inst.admin(http.MethodGet, "/admin/retries", true, &retries)
+		return retries.Total == 0
+	})
+
+	var deadLetters models.DeadLetterList
+	inst.admin(http.MethodGet, "/admin/dead-letters", true, &deadLetters)
+	if deadLetters.Total != 0 {
+		t.Errorf("%d payments were dead-lettered, want none", deadLetters.Total)
+	}
+
+	var status models.PaymentStatus
+	for _, id := range ids {
+		if inst.getJSON("/payments/"+id, &status); status.Status != models.PaymentSucceeded {
+			t.Errorf("payment %s is %q, want %q", id, status.Status, models.PaymentSucceeded)
+		}
+	}
+}
+
+func TestRequeuedAmbiguousPaymentIsNotChargedTwice(t *testing.T) {
+	ambiguous := simulatorConfig(0.05)
+	ambiguous.AmbiguousRate = 1
+	ambiguous.AmbiguousDelay = time.Second
+
+	c := newCluster(t, withProcessor("default", ambiguous), withEnv("RETRY_AMBIGUOUS", "deadletter"))
+	inst := c.start()
+	inst.waitRanked("default")
+
+	id := inst.pay(1)[0]
+
+	var deadLetter models.DeadLetter
+	waitFor(t, 5*time.Second, "the payment to be dead-lettered", func() bool {
+		return inst.admin(http.MethodGet, "/admin/dead-letters/"+id, true, &deadLetter) == http.StatusOK
+	})
+	if !slices.Equal(deadLetter.AmbiguousProcessors, []string{"default"}) {
+		t.Errorf("dead letter ambiguous processors are %v, want [default]", deadLetter.AmbiguousProcessors)
+	}
+
+	var charged models.Payment
+	if code := inst.admin(http.MethodPost, "/admin/dead-letters/"+id+"/requeue", true, &charged); code != http.StatusOK {
+		t.Fatalf("requeue answered %d, want 200 for a payment already charged", code)
+	}
+	if charged.ProcessingType != "default" {
+		t.Errorf("requeued payment was charged by %q, want default", charged.ProcessingType)
+	}
+
+	summary := inst.waitProcessed(1)
+	if got := summary["fallback"].TotalRequests; got != 0 {
+		t.Errorf("fallback charged %d payments already charged by default", got)
+	}
+	c.assertMatchesProcessors(summary)
+
+	var status models.PaymentStatus
+	if inst.getJSON("/payments/"+id, &status); status.Status != models.PaymentSucceeded {
+		t.Errorf("payment is %q, want %q", status.Status, models.PaymentSucceeded)
+	}
+}
+
+func TestRetriesGiveUpDuringOutage(t *testing.T) {
+	c := newCluster(t, withEnv("RETRY_MAX_AGE", "1s"))
+	inst := c.start()
+	inst.waitRanked("default")
+
+	for _, sim := range c.processors {
+		sim.SetFailure(true)
+	}
+	waitFor(t, 5*time.Second, "every processor to be ranked out", func() bool {
+		return len(inst.app.HealthCheckService.RankedProcessors(context.Background())) == 0
+	})
+
+	ids := inst.pay(5)
+
+	waitFor(t, 10*time.Second, "every payment to be dead-lettered", func() bool {
+		var deadLetters models.DeadLetterList
+		inst.admin(http.MethodGet, "/admin/dead-letters", true, &deadLetters)
+		return deadLetters.Total == 5
+	})
+
+	var status models.PaymentStatus
+	for _, id := range ids {
+		if inst.getJSON("/payments/"+id, &status); status.Status != models.PaymentAbandoned {
+			t.Errorf("payment %s is %q, want %q", id, status.Status, models.PaymentAbandoned)
+		}
+	}
+}
+
+func TestReclaimedRetryIsLookedUp(t *testing.T) {
+	c := newCluster(t)
+
+	// An instance claimed the retry and charged it on default, then died
+	// before saving it, leaving the entry marked as attempting
+	id := uuid.NewString()
+	payment := &models.Payment{CorrelationID: id, Amount: paymentAmount}
+	c.charge("default", payment)
+
+	now := time.Now()
+	c.redis.HSet("payment_status:"+id, "status", string(models.PaymentRetrying), "amount", paymentAmount.String())
+	c.redis.HSet("payment_retry_entries", id, c.encode(&models.RetryEntry{
+		Payment:       payment,
+		Attempts:      1,
+		FirstFailedAt: now.UTC(),
+		NextAttemptAt: now.UTC(),
+		Attempting:    true,
+	}))
+	if _, err := c.redis.ZAdd("payment_retries", float64(now.UnixMilli()), id); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+
+	inst := c.start()
+
+	summary := inst.waitProcessed(1)
+	c.assertMatchesProcessors(summary)
+
+	var deadLetters models.DeadLetterList
+	inst.admin(http.MethodGet, "/admin/dead-letters", true, &deadLetters)
+	if deadLetters.Total != 0 {
+		t.Errorf("%d payments were dead-lettered, want none", deadLetters.Total)
+	}
+
+	var status models.PaymentStatus
+	if inst.getJSON("/payments/"+id, &status); status.Status != models.PaymentSucceeded {
+		t.Errorf("payment is %q, want %q", status.Status, models.PaymentSucceeded)
+	}
+}
@@ -0,0 +1,56 @@
+package integration
+
+import (
+	"encoding/json"
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStartupRestoresUnprocessedPayments(t *testing.T) {
+	c := newCluster(t)
+
+	// Left behind by an instance that shut down before processing them
+	for range 5 {
+		c.redis.Push("unprocessed_payments", c.encode(&models.Payment{CorrelationID: uuid.NewString(), Amount: paymentAmount}))
+	}
+
+	inst := c.start()
+
+	summary := inst.waitProcessed(5)
+	c.assertMatchesProcessors(summary)
+}
+
+func TestStartupIndexesLegacyPayments(t *testing.T) {
+	c := newCluster(t)
+
+	// Stored by a version without the summary index
+	payment := &models.Payment{
+		CorrelationID:  uuid.NewString(),
+		Amount:         paymentAmount,
+		RequestedAt:    time.Now().UTC().Add(-time.Minute),
+		ProcessingType: "default",
+	}
+	c.redis.HSet("payments", payment.CorrelationID, c.encode(payment))
+
+	inst := c.start()
+
+	summary := inst.summary(nil, nil)
+	if got := summary["default"]; got.TotalRequests != 1 || got.TotalAmount != paymentAmount {
+		t.Errorf("default summary is %d payments (%s), want 1 (%s)", got.TotalRequests, got.TotalAmount, paymentAmount)
+	}
+}
+
+// encode returns v as stored in Redis.
+func (c *cluster) encode(v any) string {
+	c.t.Helper()
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatalf("failed to encode %T: %v", v, err)
+	}
+
+	return string(payload)
+}
@@ -0,0 +1,54 @@
+package integration
+
+import (
+	"francoggm/rinhabackend-2025-go-redis/internal/models"
+	"testing"
+	"time"
+)
+
+func TestSummaryWindows(t *testing.T) {
+	c := newCluster(t)
+	inst := c.start()
+	inst.waitRanked("default")
+
+	start := time.Now().UTC()
+	inst.pay(5)
+	inst.waitProcessed(5)
+
+	middle := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+
+	inst.pay(7)
+	inst.waitProcessed(12)
+	end := time.Now().UTC()
+
+	future := end.Add(time.Hour)
+	tests := []struct {
+		name     string
+		from, to *time.Time
+		want     int
+	}{
+		{name: "unbounded", want: 12},
+		{name: "whole run", from: &start, to: &end, want: 12},
+		{name: "until middle", to: &middle, want: 5},
+		{name: "from middle", from: &middle, want: 7},
+		{name: "future", from: &future, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := inst.summary(tt.from, tt.to)
+
+			stored := summary["default"]
+			if stored.TotalRequests != tt.want || stored.TotalAmount != paymentAmount*models.Money(tt.want) {
+				t.Errorf("summary has %d payments (%s), want %d", stored.TotalRequests, stored.TotalAmount, tt.want)
+			}
+
+			processed := c.processors["default"].Summary(tt.from, tt.to)
+			if processed.TotalRequests != stored.TotalRequests || processed.TotalAmount != stored.TotalAmount {
+				t.Errorf("processor recorded %d payments (%s) in the window, summary has %d (%s)",
+					processed.TotalRequests, processed.TotalAmount, stored.TotalRequests, stored.TotalAmount)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type DeadLetter struct {
+	Payment  *Payment     `json:"payment"`
+	Status   PaymentState `json:"status"`
+	Reason   string       `json:"reason"`
+	Attempts int          `json:"attempts"`
+	FailedAt time.Time    `json:"failedAt"`
+	// AmbiguousProcessors are the processors that may have charged the
+	// payment, asked for it before it is requeued.
+	AmbiguousProcessors []string `json:"ambiguousProcessors,omitempty"`
+}
+
+type DeadLetterList struct {
+	Total int64        `json:"total"`
+	Items []DeadLetter `json:"items"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount   = fmt.Errorf("invalid amount")
+	ErrAmountPrecision = fmt.Errorf("amount has more than two decimal places")
+)
+
+// Money is an exact amount in integer cents. It is encoded in JSON as a
+// decimal number with two decimal places.
+type Money int64
+
+// ParseMoney parses a plain decimal such as "19.9" or "19.90". Amounts with
+// more than two significant decimal places and exponent notation are
+// rejected instead of being rounded.
+func ParseMoney(value string) (Money, error) {
+	negative := strings.HasPrefix(value, "-")
+	if negative {
+		value = value[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(value, ".")
+	if whole == "" || (hasPoint && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return 0, ErrInvalidAmount
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > 2 {
+		return 0, ErrAmountPrecision
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	cents, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	if negative {
+		cents = -cents
+	}
+
+	return Money(cents), nil
+}
+
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+func (m Money) String() string {
+	cents := int64(m)
+
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	money, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+
+	*m = money
+	return nil
+}
+
+func isDigits(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
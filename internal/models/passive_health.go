@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PassiveHealth summarizes the payments an instance sent to a processor.
+type PassiveHealth struct {
+	SuccessRate  float64   `json:"successRate"`
+	LatencyMs    float64   `json:"latencyMs"`
+	P99LatencyMs float64   `json:"p99LatencyMs"`
+	Samples      int       `json:"samples"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
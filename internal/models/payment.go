@@ -4,17 +4,15 @@ import "time"
 
 type Payment struct {
 	CorrelationID  string    `json:"correlationId"`
-	Amount         float64   `json:"amount"`
+	Amount         Money     `json:"amount"`
 	RequestedAt    time.Time `json:"requestedAt,omitempty"`
 	ProcessingType string    `json:"processingType,omitempty"`
 }
 
 type Summary struct {
-	TotalRequests int     `json:"totalRequests"`
-	TotalAmount   float64 `json:"totalAmount"`
+	TotalRequests int   `json:"totalRequests"`
+	TotalAmount   Money `json:"totalAmount"`
 }
 
-type PaymentsSummary struct {
-	DefaultSummary  Summary `json:"default"`
-	FallbackSummary Summary `json:"fallback"`
-}
+// PaymentsSummary maps each processor name to its summary.
+type PaymentsSummary map[string]Summary
@@ -0,0 +1,28 @@
+package models
+
+type BatchItemStatus string
+
+const (
+	BatchItemAccepted  BatchItemStatus = "accepted"
+	BatchItemDuplicate BatchItemStatus = "duplicate"
+	BatchItemRejected  BatchItemStatus = "rejected"
+	// BatchItemAborted marks valid payments left out because an atomic batch
+	// was rejected as a whole.
+	BatchItemAborted BatchItemStatus = "aborted"
+)
+
+type BatchItemResult struct {
+	Index         int             `json:"index"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+	Status        BatchItemStatus `json:"status"`
+	Reason        string          `json:"reason,omitempty"`
+	InvalidParams []InvalidParam  `json:"invalidParams,omitempty"`
+}
+
+type BatchResult struct {
+	Mode       string            `json:"mode"`
+	Accepted   int               `json:"accepted"`
+	Duplicates int               `json:"duplicates"`
+	Rejected   int               `json:"rejected"`
+	Items      []BatchItemResult `json:"items"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type PaymentState string
+
+const (
+	PaymentReceived   PaymentState = "received"
+	PaymentQueued     PaymentState = "queued"
+	PaymentProcessing PaymentState = "processing"
+	PaymentRetrying   PaymentState = "retrying"
+	PaymentSucceeded  PaymentState = "succeeded"
+	PaymentFailed     PaymentState = "failed"
+	PaymentAbandoned  PaymentState = "abandoned"
+)
+
+type PaymentAttempt struct {
+	Processor   string    `json:"processor"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	LatencyMs   int64     `json:"latencyMs"`
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
+
+type PaymentStatus struct {
+	CorrelationID string           `json:"correlationId"`
+	Status        PaymentState     `json:"status"`
+	Amount        Money            `json:"amount"`
+	Processor     string           `json:"processor,omitempty"`
+	UpdatedAt     time.Time        `json:"updatedAt"`
+	Attempts      []PaymentAttempt `json:"attempts"`
+}
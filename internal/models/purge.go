@@ -0,0 +1,14 @@
+package models
+
+// PurgeReport counts what a purge deleted, or would delete on a dry run.
+type PurgeReport struct {
+	DryRun              bool     `json:"dryRun"`
+	Processors          []string `json:"processors"`
+	Payments            int64    `json:"payments"`
+	UnprocessedPayments int64    `json:"unprocessedPayments"`
+	QueuedPayments      int64    `json:"queuedPayments"`
+	IdempotencyKeys     int64    `json:"idempotencyKeys"`
+	PaymentStatuses     int64    `json:"paymentStatuses"`
+	DeadLetters         int64    `json:"deadLetters"`
+	Retries             int64    `json:"retries"`
+}
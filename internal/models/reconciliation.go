@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ProcessorSummary is the summary a processor reports on its admin API.
+type ProcessorSummary struct {
+	TotalRequests     int     `json:"totalRequests"`
+	TotalAmount       Money   `json:"totalAmount"`
+	TotalFee          Money   `json:"totalFee"`
+	FeePerTransaction float64 `json:"feePerTransaction"`
+}
+
+// ProcessorReconciliation compares what was stored for a processor with what
+// the processor recorded. Differences are the processor's totals minus ours,
+// so a positive difference means payments charged but never stored.
+type ProcessorReconciliation struct {
+	Processor        string            `json:"processor"`
+	Stored           Summary           `json:"stored"`
+	Processed        *ProcessorSummary `json:"processed,omitempty"`
+	RequestsDiff     int               `json:"requestsDiff"`
+	AmountDiff       Money             `json:"amountDiff"`
+	Error            string            `json:"error,omitempty"`
+	HasDiscrepancies bool              `json:"hasDiscrepancies"`
+}
+
+type ReconciliationReport struct {
+	From             *time.Time                `json:"from,omitempty"`
+	To               *time.Time                `json:"to,omitempty"`
+	GeneratedAt      time.Time                 `json:"generatedAt"`
+	Processors       []ProcessorReconciliation `json:"processors"`
+	HasDiscrepancies bool                      `json:"hasDiscrepancies"`
+}
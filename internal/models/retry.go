@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// RetryEntry is a payment waiting for another attempt in the retry scheduler.
+type RetryEntry struct {
+	Payment       *Payment  `json:"payment"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"firstFailedAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	LastDelayMs   int64     `json:"lastDelayMs"`
+	LastError     string    `json:"lastError,omitempty"`
+	// AmbiguousProcessors are the processors whose attempts ended without
+	// knowing whether they charged the payment.
+	AmbiguousProcessors []string `json:"ambiguousProcessors,omitempty"`
+	// Attempting is set while an instance attempts the entry, so one that
+	// claims it after the lease expired knows it may have been charged.
+	Attempting bool `json:"attempting,omitempty"`
+	// LeasedUntilMs is when the lease of a claimed entry expires.
+	LeasedUntilMs int64 `json:"-"`
+}
+
+type RetryList struct {
+	Total int64        `json:"total"`
+	Items []RetryEntry `json:"items"`
+}